@@ -0,0 +1,350 @@
+/*
+ * Whitecat Console, board file system backup and restore
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A file, or directory, of a backup archive
+type archiveEntry struct {
+	name    string
+	dir     bool
+	modTime time.Time
+	content []byte
+}
+
+// Get the archive format from the file name, zip, tar or tgz
+func archiveFormat(file string) (string, error) {
+	name := strings.ToLower(file)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip", nil
+	case strings.HasSuffix(name, ".tar"):
+		return "tar", nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tgz", nil
+	}
+
+	return "", errors.New("unknown archive format for " + file + ", use a .zip, .tar or .tar.gz file")
+}
+
+// Write the entries in an archive file
+func writeArchive(file string, entries []archiveEntry) error {
+	format, err := archiveFormat(file)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+
+	if format == "zip" {
+		w := zip.NewWriter(&buffer)
+
+		for _, entry := range entries {
+			header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+			header.SetModTime(entry.modTime)
+
+			if entry.dir {
+				header.Name = header.Name + "/"
+				header.SetMode(os.ModeDir | 0755)
+			} else {
+				header.SetMode(0644)
+			}
+
+			f, err := w.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+
+			if _, err := f.Write(entry.content); err != nil {
+				return err
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			return err
+		}
+	} else {
+		var out io.Writer = &buffer
+		var gz *gzip.Writer
+
+		if format == "tgz" {
+			gz = gzip.NewWriter(&buffer)
+			out = gz
+		}
+
+		w := tar.NewWriter(out)
+
+		for _, entry := range entries {
+			header := &tar.Header{Name: entry.name, ModTime: entry.modTime, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+
+			if entry.dir {
+				header.Name = header.Name + "/"
+				header.Mode = 0755
+				header.Typeflag = tar.TypeDir
+			}
+
+			if err := w.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if _, err := w.Write(entry.content); err != nil {
+				return err
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		if gz != nil {
+			if err := gz.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ioutil.WriteFile(file, buffer.Bytes(), 0644)
+}
+
+// Read the entries of an archive file
+func readArchive(file string) ([]archiveEntry, error) {
+	var entries []archiveEntry
+
+	format, err := archiveFormat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "zip" {
+		r, err := zip.OpenReader(file)
+		if err != nil {
+			return nil, err
+		}
+
+		defer r.Close()
+
+		for _, f := range r.File {
+			entry := archiveEntry{name: f.Name, dir: f.FileInfo().IsDir(), modTime: f.ModTime()}
+
+			if !entry.dir {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+
+				entry.content, err = ioutil.ReadAll(rc)
+				rc.Close()
+
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return entries, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var in io.Reader = f
+
+	if format == "tgz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+
+		defer gz.Close()
+
+		in = gz
+	}
+
+	r := tar.NewReader(in)
+
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		entry := archiveEntry{name: header.Name, dir: header.Typeflag == tar.TypeDir, modTime: header.ModTime}
+
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+			entry.content, err = ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+		} else if !entry.dir {
+			// Links and special files are not supported by the board
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Save the files of a directory in the board, and its subdirectories, in an
+// archive file. Returns the number of files and bytes saved.
+func (board *Board) backup(dir string, file string) (int, int, error) {
+	// Check the format before reading the files
+	if _, err := archiveFormat(file); err != nil {
+		return 0, 0, err
+	}
+
+	var found []fileEntry
+	var names []string
+
+	err := board.walk(dir, func(name string, entry fileEntry) error {
+		found = append(found, entry)
+		names = append(names, name)
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var entries []archiveEntry
+
+	files := 0
+	saved := 0
+
+	for i, entry := range found {
+		name := strings.TrimPrefix(strings.TrimPrefix(names[i], path.Clean(dir)), "/")
+
+		if entry.isDir() {
+			entries = append(entries, archiveEntry{name: name, dir: true, modTime: entry.modTime()})
+			continue
+		}
+
+		notify("progress", "\033[Kfile "+strconv.Itoa(i+1)+" of "+strconv.Itoa(len(found))+": "+names[i]+"\r\n")
+
+		content, err := board.readFile(names[i])
+		if err != nil {
+			return files, saved, err
+		}
+
+		entries = append(entries, archiveEntry{name: name, modTime: entry.modTime(), content: content})
+
+		files = files + 1
+		saved = saved + len(content)
+	}
+
+	return files, saved, writeArchive(file, entries)
+}
+
+// Restore the files of an archive file in a directory of the board. Returns
+// the number of files and bytes restored.
+func (board *Board) restore(file string, dir string) (int, int, error) {
+	entries, err := readArchive(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Entries must be inside the destination folder. All entries are checked
+	// before restoring anything.
+	root := path.Join("/", dir)
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	names := make([]string, len(entries))
+
+	for i, entry := range entries {
+		rel := path.Clean(entry.name)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return 0, 0, errors.New("invalid path in archive: " + entry.name)
+		}
+
+		names[i] = path.Join(root, rel)
+		if names[i] != root && !strings.HasPrefix(names[i], prefix) {
+			return 0, 0, errors.New("invalid path in archive: " + entry.name)
+		}
+	}
+
+	known := map[string]bool{}
+
+	if err := board.makeDirs(dir, known); err != nil {
+		return 0, 0, err
+	}
+
+	files := 0
+	restored := 0
+
+	for i, entry := range entries {
+		name := names[i]
+
+		if entry.dir {
+			if err := board.makeDirs(name, known); err != nil {
+				return files, restored, err
+			}
+
+			continue
+		}
+
+		if err := board.makeDirs(path.Dir(name), known); err != nil {
+			return files, restored, err
+		}
+
+		notify("progress", "\033[Kfile "+strconv.Itoa(i+1)+" of "+strconv.Itoa(len(entries))+": "+name+"\r\n")
+
+		if err := board.writeFile(name, entry.content); err != nil {
+			return files, restored, err
+		}
+
+		files = files + 1
+		restored = restored + len(entry.content)
+	}
+
+	return files, restored, nil
+}
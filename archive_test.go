@@ -0,0 +1,234 @@
+/*
+ * Whitecat Console, backup and restore tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestorePaths(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		dir     string
+		files   []string
+		ok      bool
+	}{
+		{
+			"relative",
+			[]archiveEntry{
+				{name: "lib", dir: true, modTime: now},
+				{name: "lib/a.lua", modTime: now, content: []byte("a")},
+				{name: "./b.lua", modTime: now, content: []byte("b")},
+			},
+			"/restored",
+			[]string{"/restored/b.lua", "/restored/lib/a.lua"},
+			true,
+		},
+		{
+			"absolute",
+			[]archiveEntry{{name: "/a.lua", modTime: now, content: []byte("a")}},
+			"/restored",
+			[]string{"/restored/a.lua"},
+			true,
+		},
+		{
+			"parent",
+			[]archiveEntry{
+				{name: "ok.lua", modTime: now, content: []byte("ok")},
+				{name: "../evil.lua", modTime: now, content: []byte("evil")},
+			},
+			"/restored",
+			nil,
+			false,
+		},
+		{
+			"nested parent",
+			[]archiveEntry{{name: "lib/../../evil.lua", modTime: now, content: []byte("evil")}},
+			"/restored",
+			nil,
+			false,
+		},
+		{
+			"parent of root",
+			[]archiveEntry{{name: "..", dir: true, modTime: now}},
+			"/",
+			nil,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		for _, format := range []string{"zip", "tar"} {
+			file := filepath.Join(t.TempDir(), "backup."+format)
+
+			if err := writeArchive(file, test.entries); err != nil {
+				t.Fatal(err)
+			}
+
+			board := openSimBoard(t)
+			sim := board.port.(*simTransport)
+
+			before := map[string]bool{}
+			for name := range sim.fs {
+				before[name] = true
+			}
+
+			_, _, err := board.restore(file, test.dir)
+			if test.ok != (err == nil) {
+				t.Errorf("%s, %s: error is %v", test.name, format, err)
+				continue
+			}
+
+			// Nothing is restored if an entry is invalid
+			var files []string
+			for name, f := range sim.fs {
+				if !before[name] && !f.dir {
+					files = append(files, name)
+				}
+			}
+
+			sort.Strings(files)
+
+			if len(files) != len(test.files) {
+				t.Errorf("%s, %s: restored files are %v, expected %v", test.name, format, files, test.files)
+				continue
+			}
+
+			for i := range files {
+				if files[i] != test.files[i] {
+					t.Errorf("%s, %s: restored files are %v, expected %v", test.name, format, files, test.files)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	for _, format := range []string{"zip", "tar", "tar.gz"} {
+		file := filepath.Join(t.TempDir(), "backup."+format)
+
+		board := openSimBoard(t)
+		sim := board.port.(*simTransport)
+
+		if err := board.makeDirs("/lib/empty", map[string]bool{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := board.writeFile("/lib/util.lua", []byte("util()\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		files, saved, err := board.backup("/", file)
+		if err != nil {
+			t.Fatalf("%s: backup failed: %v", format, err)
+		}
+
+		if files != 3 || saved != 80 {
+			t.Errorf("%s: %d files and %d bytes saved, expected 3 files and 80 bytes", format, files, saved)
+		}
+
+		// The archive has relative paths, and the dates of the board
+		entries, err := readArchive(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, entry := range entries {
+			name := "/" + strings.TrimSuffix(entry.name, "/")
+
+			f, ok := sim.fs[name]
+			if !ok || f.dir != entry.dir || string(f.content) != string(entry.content) {
+				t.Errorf("%s: entry %s is %+v", format, entry.name, entry)
+			}
+
+			if ok && !entry.modTime.Equal(f.modTime.Truncate(time.Minute)) {
+				t.Errorf("%s: %s date is %v, expected %v", format, entry.name, entry.modTime, f.modTime)
+			}
+		}
+
+		// Restore in other board, and in a folder
+		restored := openSimBoard(t)
+
+		files, written, err := restored.restore(file, "/restored")
+		if err != nil {
+			t.Fatalf("%s: restore failed: %v", format, err)
+		}
+
+		if files != 3 || written != 80 {
+			t.Errorf("%s: %d files and %d bytes restored, expected 3 files and 80 bytes", format, files, written)
+		}
+
+		fs := restored.port.(*simTransport).fs
+
+		for name, f := range sim.fs {
+			if name == "/" {
+				continue
+			}
+
+			r, ok := fs["/restored"+name]
+			if !ok || r.dir != f.dir || string(r.content) != string(f.content) {
+				t.Errorf("%s: %s not restored", format, name)
+			}
+		}
+	}
+}
+
+func TestArchiveFormat(t *testing.T) {
+	tests := []struct {
+		file   string
+		format string
+	}{
+		{"backup.zip", "zip"},
+		{"backup.TAR", "tar"},
+		{"backup.tar.gz", "tgz"},
+		{"backup.tgz", "tgz"},
+		{"backup.rar", ""},
+	}
+
+	for _, test := range tests {
+		format, err := archiveFormat(test.file)
+		if format != test.format || (err == nil) != (test.format != "") {
+			t.Errorf("%s: format is %q, %v, expected %q", test.file, format, err, test.format)
+		}
+	}
+
+	if _, err := readArchive(filepath.Join(os.TempDir(), "nope.zip")); err == nil {
+		t.Error("missing archive read")
+	}
+}
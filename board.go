@@ -35,7 +35,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/mikepb/go-serial"
 	"io/ioutil"
 	"log"
 	"math"
@@ -44,6 +43,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -61,13 +61,19 @@ type SupportedBoards []SupportedBoard
 var Upgrading bool
 
 type Board struct {
-	// Serial port
-	port    *serial.Port
-	devInfo *serial.Info
+	// Transport used for talk with the board
+	port Transport
 
 	// Device name
 	dev string
 
+	// USB serial number, used for find the board when it's reconnected
+	serialNumber string
+
+	// Port was closed by the console, accessed atomically because it's read
+	// by the inspector
+	closed int32
+
 	// Is there a new firmware build?
 	newBuild bool
 
@@ -93,6 +99,8 @@ type Board struct {
 	consoleOut bool
 	consoleIn  bool
 
+	// Closed when the inspector stops, because the port was closed or the
+	// board was disconnected
 	quit chan bool
 
 	// Current timeout value, in milliseconds for read
@@ -100,6 +108,9 @@ type Board struct {
 
 	// Firmware is valid?
 	validFirmware bool
+
+	// Number of Lua runtime errors found by the inspector
+	runtimeErrors int32
 }
 
 type BoardInfo struct {
@@ -129,8 +140,7 @@ func (board *Board) inspector() {
 	defer func() {
 		log.Println("stop inspector ...")
 
-		if err := recover(); err != nil {
-		}
+		close(board.quit)
 	}()
 
 	log.Println("start inspector ...")
@@ -139,9 +149,27 @@ func (board *Board) inspector() {
 
 	line := ""
 
+	// Firmware panics found, and the decoded backtrace of the last one, that is
+	// sent to the console after the backtrace line
+	panics := panicParser{}
+	decoded := ""
+
+	// Lua runtime error in progress, that is notified when its stack
+	// traceback ends
+	tracebacks := tracebackParser{}
+
 	for {
 		if n, err := board.port.Read(buffer); err != nil {
-			panic(err)
+			if info := tracebacks.end(); info != "" {
+				notify("boardRuntimeError", info)
+			}
+
+			if !board.isClosed() {
+				log.Println("board detached:", err)
+				notify("boardDetached", "")
+			}
+
+			return
 		} else {
 			if n > 0 {
 				if buffer[0] == '\n' {
@@ -185,44 +213,25 @@ func (board *Board) inspector() {
 						}
 					}
 
-					// Remove prompt from line
-					tmpLine := line
-					re = regexp.MustCompile(`^/.*>\s`)
-					tmpLine = string(re.ReplaceAll([]byte(tmpLine), []byte("")))
-
-					re = regexp.MustCompile(`^([\/\.\/\-_a-zA-Z]*):(\d*)\:\s(\d*)\:(.*)$`)
-					if re.MatchString(tmpLine) {
-						parts := re.FindStringSubmatch(tmpLine)
-
-						info := "\"where\": \"" + parts[1] + "\", " +
-							"\"line\": \"" + parts[2] + "\", " +
-							"\"exception\": \"" + parts[3] + "\", " +
-							"\"message\": \"" + base64.StdEncoding.EncodeToString([]byte(parts[4])) + "\""
-						log.Println(parts[4])
-
-						re = regexp.MustCompile(`^WARNING\s.*$`)
-						if re.MatchString(parts[4]) {
-							notify("boardRuntimeWarning", info)
-						} else {
-							notify("boardRuntimeError", info)
-						}
-					} else {
-						re = regexp.MustCompile(`^([\/\.\/\-_a-zA-Z]*)\:(\d*)\:\s*(.*)$`)
-						if re.MatchString(tmpLine) {
-							parts := re.FindStringSubmatch(tmpLine)
-
-							info := "\"where\": \"" + parts[1] + "\", " +
-								"\"line\": \"" + parts[2] + "\", " +
-								"\"exception\": \"0\", " +
-								"\"message\": \"" + base64.StdEncoding.EncodeToString([]byte(parts[3])) + "\""
-
-							re = regexp.MustCompile(`^WARNING\s.*$`)
-							if re.MatchString(parts[3]) {
-								notify("boardRuntimeWarning", info)
-							} else {
-								notify("boardRuntimeError", info)
-							}
+					if info := tracebacks.parse(line); info != "" {
+						notify("boardRuntimeError", info)
+					}
+
+					if notification, info := parseRuntimeMessage(line); notification == "boardRuntimeError" {
+						atomic.AddInt32(&board.runtimeErrors, 1)
+
+						if previous := tracebacks.start(info); previous != "" {
+							notify("boardRuntimeError", previous)
 						}
+					} else if notification != "" {
+						notify(notification, info)
+					}
+
+					if bp := panics.parse(line); bp != nil {
+						bp.decode(getSymbolizer(board.firmware))
+						decoded = bp.decodedBacktrace()
+
+						notify("boardPanic", bp.info())
 					}
 
 					line = ""
@@ -230,12 +239,22 @@ func (board *Board) inspector() {
 					if buffer[0] != '\r' {
 						line = line + string(buffer[0])
 					}
+
+					if info := tracebacks.partial(line); info != "" {
+						notify("boardRuntimeError", info)
+					}
 				}
 
 				if board.consoleOut {
 					ConsoleUp <- buffer[0]
+
+					for _, c := range []byte(decoded) {
+						ConsoleUp <- c
+					}
 				}
 
+				decoded = ""
+
 				if board.consoleIn {
 					board.RXQueue <- buffer[0]
 				}
@@ -244,44 +263,63 @@ func (board *Board) inspector() {
 	}
 }
 
-func (board *Board) attach(info *serial.Info) {
-	defer func() {
-		if err := recover(); err != nil {
-			board.detach()
+// Parse a line received from the board, looking for a Lua runtime error or
+// warning. Returns the notification and the notification info, or an empty
+// notification if line is not an error or warning.
+func parseRuntimeMessage(line string) (string, string) {
+	var re *regexp.Regexp
+
+	// Remove prompt from line
+	tmpLine := line
+	re = regexp.MustCompile(`^/.*>\s`)
+	tmpLine = string(re.ReplaceAll([]byte(tmpLine), []byte("")))
 
-			connectedBoard = board
+	re = regexp.MustCompile(`^([\/\.\/\-_a-zA-Z]*):(\d*)\:\s(\d*)\:(.*)$`)
+	if re.MatchString(tmpLine) {
+		parts := re.FindStringSubmatch(tmpLine)
 
-			connectedBoard.validFirmware = false
-			connectedBoard.model = ""
-			connectedBoard.subtype = ""
-			connectedBoard.brand = ""
+		info := "\"where\": \"" + parts[1] + "\", " +
+			"\"line\": \"" + parts[2] + "\", " +
+			"\"exception\": \"" + parts[3] + "\", " +
+			"\"message\": \"" + base64.StdEncoding.EncodeToString([]byte(parts[4])) + "\""
+		log.Println(parts[4])
 
-			panic(err)
+		re = regexp.MustCompile(`^WARNING\s.*$`)
+		if re.MatchString(parts[4]) {
+			return "boardRuntimeWarning", info
 		} else {
-			log.Println("board attached")
+			return "boardRuntimeError", info
+		}
+	} else {
+		re = regexp.MustCompile(`^([\/\.\/\-_a-zA-Z]*)\:(\d*)\:\s*(.*)$`)
+		if re.MatchString(tmpLine) {
+			parts := re.FindStringSubmatch(tmpLine)
+
+			info := "\"where\": \"" + parts[1] + "\", " +
+				"\"line\": \"" + parts[2] + "\", " +
+				"\"exception\": \"0\", " +
+				"\"message\": \"" + base64.StdEncoding.EncodeToString([]byte(parts[3])) + "\""
+
+			re = regexp.MustCompile(`^WARNING\s.*$`)
+			if re.MatchString(parts[3]) {
+				return "boardRuntimeWarning", info
+			} else {
+				return "boardRuntimeError", info
+			}
 		}
-	}()
-
-	log.Println("attaching board ...")
-
-	board.devInfo = info
-
-	// Configure options or serial port connection
-	options := serial.RawOptions
-	options.BitRate = 115200
-	options.Mode = serial.MODE_READ_WRITE
-	options.DTR = serial.DTR_OFF
-	options.RTS = serial.RTS_OFF
-
-	// Open port
-	port, openErr := options.Open(info.Name())
-	if openErr != nil {
-		panic(openErr)
 	}
 
+	return "", ""
+}
+
+// Open the board on a transport, and start inspecting the data received,
+// without reset the board
+func (board *Board) open(port Transport, dev string) {
 	// Create board struct
 	board.port = port
-	board.dev = info.Name()
+	board.dev = dev
+	board.serialNumber = ""
+	board.setClosed(false)
 	board.RXQueue = make(chan byte, 10*1024)
 	board.chunkSize = 255
 	board.disableInspectorBootNotify = false
@@ -291,86 +329,137 @@ func (board *Board) attach(info *serial.Info) {
 	board.timeoutVal = math.MaxInt32
 	board.validFirmware = true
 
+	if _, ok := port.(*serialTransport); ok {
+		board.serialNumber = portSerialNumber(dev)
+	}
+
 	Upgrading = false
 
 	go board.inspector()
+}
+
+func (board *Board) attach(port Transport, dev string) error {
+	log.Println("attaching board ...")
+
+	board.open(port, dev)
 
 	// Reset the board
-	board.reset()
-	connectedBoard = board
+	if err := board.reset(); err != nil {
+		board.detach()
+
+		board.validFirmware = false
+		board.model = ""
+		board.subtype = ""
+		board.brand = ""
+
+		setConnectedBoard(board)
+
+		return err
+	}
+
+	setConnectedBoard(board)
+
+	log.Println("board attached")
 
 	notify("boardAttached", "")
+
+	return nil
 }
 
 func (board *Board) detach() {
 	log.Println("detaching board ...")
 
 	// Close board
-	if connectedBoard != nil {
-		log.Println("closing serial port ...")
+	if currentBoard() != nil {
+		log.Println("closing port ...")
 
-		// Close serial port
+		// Close port
+		board.setClosed(true)
 		board.port.Close()
 
 		time.Sleep(time.Millisecond * 1000)
 	}
 
-	connectedBoard = nil
+	setConnectedBoard(nil)
+}
+
+// Set if the port was closed by the console
+func (board *Board) setClosed(closed bool) {
+	if closed {
+		atomic.StoreInt32(&board.closed, 1)
+	} else {
+		atomic.StoreInt32(&board.closed, 0)
+	}
+}
+
+// Port was closed by the console?
+func (board *Board) isClosed() bool {
+	return atomic.LoadInt32(&board.closed) != 0
 }
 
 /*
- * Serial port primitives
+ * Transport primitives
  */
 
-// Read one byte from RXQueue
-func (board *Board) read() byte {
+// Read one byte from RXQueue. If the board is detached, pending bytes are
+// returned before the error.
+func (board *Board) read() (byte, error) {
+	var timeout <-chan time.Time
+
 	if board.timeoutVal != math.MaxInt32 {
-		for {
-			select {
-			case c := <-board.RXQueue:
-				return c
-			case <-time.After(time.Millisecond * time.Duration(board.timeoutVal)):
-				panic(errors.New("timeout"))
-			}
+		timeout = time.After(time.Millisecond * time.Duration(board.timeoutVal))
+	}
+
+	select {
+	case c := <-board.RXQueue:
+		return c, nil
+	case <-timeout:
+		return 0, errTimeout
+	case <-board.quit:
+		select {
+		case c := <-board.RXQueue:
+			return c, nil
+		default:
+			return 0, errDetached
 		}
-	} else {
-		return <-board.RXQueue
 	}
 }
 
 // Read one line from RXQueue
-func (board *Board) readLineCRLF() string {
+func (board *Board) readLineCRLF() (string, error) {
 	var buffer bytes.Buffer
-	var b byte
 
 	for {
-		b = board.read()
+		b, err := board.read()
+		if err != nil {
+			return "", err
+		}
+
 		if b == '\n' {
-			return buffer.String()
+			return buffer.String(), nil
 		} else {
 			if b != '\r' {
 				buffer.WriteString(string(rune(b)))
 			}
 		}
 	}
-
-	return ""
 }
 
-func (board *Board) readLineCR() string {
+func (board *Board) readLineCR() (string, error) {
 	var buffer bytes.Buffer
-	var b byte
 
 	for {
-		b = board.read()
+		b, err := board.read()
+		if err != nil {
+			return "", err
+		}
+
 		if b == '\r' {
-			return buffer.String()
+			return buffer.String(), nil
 		} else {
 			buffer.WriteString(string(rune(b)))
 		}
 	}
-
-	return ""
 }
 
 func (board *Board) consume() {
@@ -381,35 +470,37 @@ func (board *Board) consume() {
 	}
 }
 
-// Wait until board is ready
-func (board *Board) waitForReady() bool {
+// Wait until board is ready. Returns false if the board hasn't a valid
+// firmware.
+func (board *Board) waitForReady() (bool, error) {
 	failingBack := 0
 
-	line := ""
-
 	log.Println("waiting fot ready ...")
 
-	board.timeout(4000)
+	board.timeout(config.Timeouts.Boot)
 
 	timeout := time.After(time.Millisecond * time.Duration(board.timeoutVal))
 
 	for {
 		select {
 		case <-timeout:
-			panic(errors.New("timeout"))
+			return false, errTimeout
 		default:
-			line = board.readLineCRLF()
+			line, err := board.readLineCRLF()
+			if err != nil {
+				return false, err
+			}
 
 			if regexp.MustCompile(`^.*boot: Failed to verify app image.*$`).MatchString(line) {
 				board.validFirmware = false
 				notify("boardUpdate", "Corrupted firmware")
-				return false
+				return false, nil
 			}
 
 			if regexp.MustCompile(`^.*boot: No bootable app partitions in the partition table.*$`).MatchString(line) {
 				board.validFirmware = false
 				notify("boardUpdate", "Corrupted firmware")
-				return false
+				return false, nil
 			}
 
 			if regexp.MustCompile(`^Falling back to built-in command interpreter.$`).MatchString(line) {
@@ -417,7 +508,7 @@ func (board *Board) waitForReady() bool {
 				if failingBack > 4 {
 					board.validFirmware = false
 					notify("boardUpdate", "Flash error")
-					return false
+					return false, nil
 				}
 			}
 
@@ -426,7 +517,7 @@ func (board *Board) waitForReady() bool {
 				if failingBack > 4 {
 					board.validFirmware = false
 					notify("boardUpdate", "Flash error")
-					return false
+					return false, nil
 				}
 			}
 
@@ -436,56 +527,68 @@ func (board *Board) waitForReady() bool {
 			}
 
 			if regexp.MustCompile(`^Lua RTOS-boot-scripts-aborted-ESP32$`).MatchString(line) {
-				return true
+				return true, nil
 			}
 		}
 	}
 }
 
-// Test if line corresponds to Lua RTOS prompt
-func isPrompt(line string) bool {
-	return regexp.MustCompile("^/.*>.*$").MatchString(line)
+// Synchronize with the board's prompt, without reset the board. This is
+// used for boards that can't be reset, for example boards connected through
+// the network, or when the board must be attached without reset it.
+func (board *Board) syncPrompt() error {
+	log.Println("waiting for prompt ...")
+
+	for retry := 0; retry < 3; retry++ {
+		if board.trySyncPrompt() {
+			return nil
+		}
+	}
+
+	return classify(exitTimeout, "", errors.New("board is not responding at "+board.dev))
 }
 
-// Send a command to the board
-func (board *Board) sendCommand(command string) string {
-	var response string = ""
+// Try to synchronize with the board's prompt. Any running script is
+// interrupted sending a Ctrl-C, and then a new line is sent, so board must
+// answer with the prompt.
+func (board *Board) trySyncPrompt() bool {
+	// Send Ctrl-C
+	board.port.Write([]byte{3})
+	board.consume()
+
+	board.timeout(1000)
 
-	// Send command. We must append the \r\n chars at the end
-	board.port.Write([]byte(command + "\r\n"))
+	// Send a new line
+	board.port.Write([]byte("\r\n"))
 
-	// Read response, that it must be the send command.
-	line := board.readLineCRLF()
-	if line == command {
-		// Read until prompt
-		for {
-			line = board.readLineCRLF()
-			log.Print(line)
-			if isPrompt(line) {
-				return response
-			} else {
-				if response != "" {
-					response = response + "\r\n"
-				}
-				response = response + line
-			}
+	// A script that can't be interrupted can send data forever, so stop
+	// waiting at some point
+	deadline := time.Now().Add(time.Millisecond * 2000)
+
+	for time.Now().Before(deadline) {
+		line, err := board.readLineCRLF()
+		if err != nil {
+			return false
+		}
+
+		if isPrompt(line) {
+			return true
 		}
-	} else {
-		return ""
 	}
 
-	return ""
+	return false
+}
+
+// Test if line corresponds to Lua RTOS prompt
+func isPrompt(line string) bool {
+	return regexp.MustCompile("^/.*>.*$").MatchString(line)
 }
 
-func (board *Board) reset() {
+func (board *Board) reset() error {
 	defer func() {
 		board.noTimeout()
 		board.consoleOut = true
 		board.consoleIn = false
-
-		if err := recover(); err != nil {
-			panic(err)
-		}
 	}()
 
 	board.consume()
@@ -493,89 +596,62 @@ func (board *Board) reset() {
 	board.consoleOut = false
 	board.consoleIn = true
 
-	// Reset board
-	options := serial.RawOptions
-	options.BitRate = 115200
-	options.Mode = serial.MODE_READ_WRITE
-
-	options.RTS = serial.RTS_OFF
-	board.port.Apply(&options)
+	// If board can't be reset, or reset is not wanted, simply wait for
+	// the prompt
+	if !board.port.HasControlLines() || attachConfig.Reset == "none" {
+		if err := board.syncPrompt(); err != nil {
+			return err
+		}
 
-	time.Sleep(time.Millisecond * 10)
+		board.consume()
 
-	options.RTS = serial.RTS_ON
-	board.port.Apply(&options)
+		log.Println("board is ready ...")
 
-	time.Sleep(time.Millisecond * 10)
+		return nil
+	}
 
-	options.RTS = serial.RTS_OFF
-	board.port.Apply(&options)
+	// Reset board
+	attachConfig.resetPort(board.port)
 
-	if !board.waitForReady() {
-		return
+	ready, err := board.waitForReady()
+	if err != nil || !ready {
+		return err
 	}
 
 	board.consume()
 
 	log.Println("board is ready ...")
-}
 
-func (board *Board) getDirContent(path string) string {
-	var content string
-
-	defer func() {
-		board.noTimeout()
-		board.consoleOut = true
-		board.consoleIn = false
-
-		if err := recover(); err != nil {
-		}
-	}()
-
-	content = ""
-
-	board.consoleOut = false
-	board.consoleIn = true
-
-	board.timeout(1000)
-	response := board.sendCommand("os.ls(\"" + path + "\")")
-	for _, line := range strings.Split(response, "\n") {
-		element := strings.Split(strings.Replace(line, "\r", "", -1), "\t")
-
-		if len(element) == 4 {
-			if content != "" {
-				content = content + ","
-			}
+	return nil
+}
 
-			content = content + "{" +
-				"\"type\": \"" + element[0] + "\"," +
-				"\"size\": \"" + element[1] + "\"," +
-				"\"date\": \"" + element[2] + "\"," +
-				"\"name\": \"" + element[3] + "\"" +
-				"}"
-		}
+// Get the content of a directory as a JSON array, with an object per entry
+func (board *Board) getDirContent(path string) (string, error) {
+	entries, err := board.list(path)
+	if err != nil {
+		return "", err
 	}
 
-	board.consoleOut = true
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
 
-	return "[" + content + "]"
+	return string(content), nil
 }
 
-func (board *Board) writeFile(path string, buffer []byte) string {
+func (board *Board) writeFile(path string, buffer []byte) error {
 	defer func() {
 		board.noTimeout()
 		board.consoleOut = true
 		board.consoleIn = false
-
-		if err := recover(); err != nil {
-		}
 	}()
 
-	board.timeout(2000)
+	board.timeout(config.Timeouts.Transfer)
 	board.consoleOut = false
 	board.consoleIn = true
 
-	writeCommand := "io.receive(\"" + path + "\")"
+	writeCommand := "io.receive(" + luaQuote(path) + ")"
 
 	outLen := 0
 	outIndex := 0
@@ -586,10 +662,21 @@ func (board *Board) writeFile(path string, buffer []byte) string {
 
 	// Send command and test for echo
 	board.port.Write([]byte(writeCommand + "\r"))
-	if board.readLineCR() == writeCommand {
+
+	line, err := board.readLineCR()
+	if err != nil {
+		return classify(exitTransferFailed, "can't send "+path, err)
+	}
+
+	if line == writeCommand {
 		for {
 			// Wait for chunk
-			if board.readLineCRLF() == "C" {
+			line, err = board.readLineCRLF()
+			if err != nil {
+				return classify(exitTransferFailed, "can't send "+path, err)
+			}
+
+			if line == "C" {
 				// Get chunk length
 				if outIndex < len(buffer) {
 					if outIndex+board.chunkSize < len(buffer) {
@@ -617,57 +704,103 @@ func (board *Board) writeFile(path string, buffer []byte) string {
 			}
 		}
 
-		if board.readLineCRLF() == "true" {
+		line, err = board.readLineCRLF()
+		if err != nil {
+			return classify(exitTransferFailed, "can't send "+path, err)
+		}
+
+		if line == "true" {
 			board.consume()
 
 			notify("progress", "\033[Kfile sended\r")
 
-			return "ok"
+			return nil
 		}
 	}
 
-	return ""
+	return classify(exitTransferFailed, "", errors.New("can't send "+path+", board refused the file"))
 }
 
-func (board *Board) readFile(path string) []byte {
+// Start of the Lua errors raised by the commands typed in the shell
+const luaShellError = "stdin:1: "
+
+func (board *Board) readFile(path string) ([]byte, error) {
 	defer func() {
 		board.noTimeout()
 		board.consoleOut = true
 		board.consoleIn = false
-
-		if err := recover(); err != nil {
-		}
 	}()
 
 	var buffer bytes.Buffer
 	var inLen byte
 	var received int
 
-	board.timeout(2000)
+	board.timeout(config.Timeouts.Transfer)
 	board.consoleOut = false
 	board.consoleIn = true
 
 	received = 0
 
 	// Command for read file
-	readCommand := "io.send(\"" + path + "\")"
+	readCommand := "io.send(" + luaQuote(path) + ")"
 
 	notify("progress", "\033[K"+strconv.Itoa(received)+" bytes received ...\r")
 
 	// Send command and test for echo
 	board.port.Write([]byte(readCommand + "\r"))
-	if board.readLineCRLF() == readCommand {
+
+	line, err := board.readLineCRLF()
+	if err != nil {
+		return nil, classify(exitTransferFailed, "can't receive "+path, err)
+	}
+
+	if line == readCommand {
 		for {
 			// Wait for chunk
 			board.port.Write([]byte("C\n"))
 
 			// Read chunk size
-			inLen = board.read()
+			inLen, err = board.read()
+			if err != nil {
+				return nil, classify(exitTransferFailed, "can't receive "+path, err)
+			}
+
+			// If io.send fails, for example if the file doesn't exist, the
+			// board sends the Lua error instead of the first chunk
+			if received == 0 && inLen == luaShellError[0] {
+				prefix := []byte{inLen}
+
+				for len(prefix) < len(luaShellError) && prefix[len(prefix)-1] == luaShellError[len(prefix)-1] {
+					c, err := board.read()
+					if err != nil {
+						return nil, classify(exitTransferFailed, "can't receive "+path, err)
+					}
+
+					prefix = append(prefix, c)
+				}
+
+				if string(prefix) == luaShellError {
+					message, _ := board.readLineCRLF()
+					board.consume()
+
+					return nil, classify(exitTransferFailed, "can't receive "+path, errors.New(strings.TrimSpace(message)))
+				}
+
+				// The bytes read are the start of the chunk
+				buffer.Write(prefix[1:])
+				inLen = inLen - byte(len(prefix)-1)
+				received = received + len(prefix) - 1
+			}
 
 			// Read chunk
 			if inLen > 0 {
 				for inLen > 0 {
-					buffer.WriteByte(board.read())
+					c, err := board.read()
+					if err != nil {
+						return nil, classify(exitTransferFailed, "can't receive "+path, err)
+					}
+
+					buffer.WriteByte(c)
 
 					inLen = inLen - 1
 					received = received + 1
@@ -684,74 +817,98 @@ func (board *Board) readFile(path string) []byte {
 
 		notify("progress", "\n\033[Kfile received\r\n")
 
-		return buffer.Bytes()
+		return buffer.Bytes(), nil
 	}
 
-	return nil
+	return nil, classify(exitTransferFailed, "", errors.New("can't receive "+path+", board refused the file"))
 }
 
-func (board *Board) upgrade(erase bool, flash bool, flashFS bool) {
-	var boardName string
+// Run esptool with the arguments in args. Each line written by esptool is
+// passed to the output function.
+func runEsptool(args string, output func(string)) error {
 	var out string = ""
 
-	Upgrading = true
+	// Build the flash command
+	cmdArgs := regexp.MustCompile(`'.*?'|".*?"|\S+`).FindAllString(args, -1)
 
-	// First detach board for free serial port
-	board.detach()
+	for i, _ := range cmdArgs {
+		cmdArgs[i] = strings.Replace(cmdArgs[i], "\"", "", -1)
+	}
 
-	// Download tool for flashing
-	err := downloadEsptool()
+	// Prepare for execution
+	cmd := exec.Command(AppDataTmpFolder+"/utils/esptool/esptool", cmdArgs...)
+
+	log.Println("executing: ", "\""+AppDataTmpFolder+"/utils/esptool/esptool\"")
+
+	// We need to read command stdout for show the progress in the IDE
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		notify("boardUpdate", err.Error())
-		time.Sleep(time.Millisecond * 1000)
-		Upgrading = false
+		return err
+	}
 
-		return
+	// Start
+	err = cmd.Start()
+	if err != nil {
+		return err
 	}
 
-	if erase {
-		notify("progress", "Erasing flash")
+	// Read stdout until EOF
+	c := make([]byte, 1)
+	for {
+		_, err := stdout.Read(c)
+		if err != nil {
+			break
+		}
 
-		flash_args := "--chip esp32 --port " + board.dev + " --baud 115200 erase_flash"
+		if c[0] == '\r' || c[0] == '\n' {
+			out = strings.Replace(out, "...", "", -1)
+			if out != "" {
+				output(out)
+			}
+			out = ""
+		} else {
+			out = out + string(c)
+		}
 
-		// Build the flash command
-		cmdArgs := regexp.MustCompile(`'.*?'|".*?"|\S+`).FindAllString(flash_args, -1)
+	}
 
-		for i, _ := range cmdArgs {
-			cmdArgs[i] = strings.Replace(cmdArgs[i], "\"", "", -1)
-		}
+	return cmd.Wait()
+}
 
-		// Prepare for execution
-		cmd := exec.Command(AppDataTmpFolder+"/utils/esptool/esptool", cmdArgs...)
+func (board *Board) upgrade(erase bool, flash bool, flashFS bool) error {
+	var boardName string
 
-		log.Println("executing: ", "\""+AppDataTmpFolder+"/utils/esptool/esptool\"")
+	if !board.port.HasControlLines() || isSimulator(board.dev) {
+		return classify(exitFlashFailed, "", errors.New("board at "+board.dev+" can't be flashed, use a serial port"))
+	}
 
-		// We need to read command stdout for show the progress in the IDE
-		stdout, _ := cmd.StdoutPipe()
+	Upgrading = true
 
-		notify("progress", "\r                   \r")
+	defer func() {
+		Upgrading = false
+	}()
 
-		// Start
-		cmd.Start()
+	// First detach board for free serial port
+	board.detach()
 
-		// Read stdout until EOF
-		c := make([]byte, 1)
-		for {
-			_, err := stdout.Read(c)
-			if err != nil {
-				break
-			}
+	// Download tool for flashing
+	err := downloadEsptool()
+	if err != nil {
+		return classify(exitDownloadFailed, "", err)
+	}
 
-			if c[0] == '\r' || c[0] == '\n' {
-				out = strings.Replace(out, "...", "", -1)
-				if out != "" {
-					notify("progress", "Erasing flash ...\r")
-				}
-				out = ""
-			} else {
-				out = out + string(c)
-			}
+	if erase {
+		notify("progress", "Erasing flash")
+
+		flash_args := "--chip esp32 --port " + board.dev + " --baud 115200 erase_flash"
+
+		notify("progress", "\r                   \r")
 
+		err = runEsptool(flash_args, func(out string) {
+			notify("progress", "Erasing flash ...\r")
+		})
+		if err != nil {
+			return classify(exitFlashFailed, "can't erase flash", err)
 		}
 
 		log.Println("Erased")
@@ -761,16 +918,15 @@ func (board *Board) upgrade(erase bool, flash bool, flashFS bool) {
 		// Download firmware
 		err = downloadFirmware(board.firmware)
 		if err != nil {
-			notify("boardUpdate", err.Error())
-			time.Sleep(time.Millisecond * 1000)
-			Upgrading = false
-
-			return
+			return classify(exitDownloadFailed, "", err)
 		}
 
 		// Get the board name part of the firmware files for
 		// current board model
-		boardName = board.getFirmwareName()
+		boardName, err = board.getFirmwareName()
+		if err != nil {
+			return classify(exitDownloadFailed, "", err)
+		}
 
 		log.Println("board name: ", boardName)
 	}
@@ -781,11 +937,7 @@ func (board *Board) upgrade(erase bool, flash bool, flashFS bool) {
 		// Read flash arguments
 		b, err := ioutil.ReadFile(AppDataTmpFolder + "/firmware_files/flash_args")
 		if err != nil {
-			notify("boardUpdate", err.Error())
-			time.Sleep(time.Millisecond * 1000)
-			Upgrading = false
-
-			return
+			return classify(exitFlashFailed, "", err)
 		}
 
 		flash_args := string(b)
@@ -803,42 +955,11 @@ func (board *Board) upgrade(erase bool, flash bool, flashFS bool) {
 
 		log.Println("flash args: ", flash_args)
 
-		// Build the flash command
-		cmdArgs := regexp.MustCompile(`'.*?'|".*?"|\S+`).FindAllString(flash_args, -1)
-
-		for i, _ := range cmdArgs {
-			cmdArgs[i] = strings.Replace(cmdArgs[i], "\"", "", -1)
-		}
-
-		// Prepare for execution
-		cmd := exec.Command(AppDataTmpFolder+"/utils/esptool/esptool", cmdArgs...)
-
-		log.Println("executing: ", "\""+AppDataTmpFolder+"/utils/esptool/esptool\"")
-
-		// We need to read command stdout for show the progress in the IDE
-		stdout, _ := cmd.StdoutPipe()
-
-		// Start
-		cmd.Start()
-
-		// Read stdout until EOF
-		c := make([]byte, 1)
-		for {
-			_, err := stdout.Read(c)
-			if err != nil {
-				break
-			}
-
-			if c[0] == '\r' || c[0] == '\n' {
-				out = strings.Replace(out, "...", "", -1)
-				if out != "" {
-					notify("boardUpdate", out)
-				}
-				out = ""
-			} else {
-				out = out + string(c)
-			}
-
+		err = runEsptool(flash_args, func(out string) {
+			notify("boardUpdate", out)
+		})
+		if err != nil {
+			return classify(exitFlashFailed, "can't flash firmware", err)
 		}
 
 		log.Println("Upgraded")
@@ -850,11 +971,7 @@ func (board *Board) upgrade(erase bool, flash bool, flashFS bool) {
 		// Read flash arguments
 		b, err := ioutil.ReadFile(AppDataTmpFolder + "/firmware_files/flashfs_args")
 		if err != nil {
-			notify("boardUpdate", err.Error())
-			time.Sleep(time.Millisecond * 1000)
-			Upgrading = false
-
-			return
+			return classify(exitFlashFailed, "", err)
 		}
 
 		flash_args := string(b)
@@ -866,85 +983,68 @@ func (board *Board) upgrade(erase bool, flash bool, flashFS bool) {
 
 		log.Println("flash args: ", flash_args)
 
-		// Build the flash command
-		cmdArgs := regexp.MustCompile(`'.*?'|".*?"|\S+`).FindAllString(flash_args, -1)
-
-		for i, _ := range cmdArgs {
-			cmdArgs[i] = strings.Replace(cmdArgs[i], "\"", "", -1)
+		err = runEsptool(flash_args, func(out string) {
+			notify("boardUpdate", out)
+		})
+		if err != nil {
+			return classify(exitFlashFailed, "can't flash file system", err)
 		}
 
-		// Prepare for execution
-		cmd := exec.Command(AppDataTmpFolder+"/utils/esptool/esptool", cmdArgs...)
+		log.Println("Upgraded")
+	}
 
-		log.Println("executing: ", "\""+AppDataTmpFolder+"/utils/esptool/esptool\"")
+	time.Sleep(time.Millisecond * 1000)
 
-		// We need to read command stdout for show the progress in the IDE
-		stdout, _ := cmd.StdoutPipe()
+	return nil
+}
 
-		// Start
-		cmd.Start()
+// Get the supported boards
+func getSupportedBoards() (SupportedBoards, error) {
+	var supportedBoards SupportedBoards
 
-		// Read stdout until EOF
-		c := make([]byte, 1)
-		for {
-			_, err := stdout.Read(c)
-			if err != nil {
-				break
-			}
+	resp, err := http.Get(SupportedBoardsURL)
+	if err != nil {
+		return nil, err
+	}
 
-			if c[0] == '\r' || c[0] == '\n' {
-				out = strings.Replace(out, "...", "", -1)
-				if out != "" {
-					notify("boardUpdate", out)
-				}
-				out = ""
-			} else {
-				out = out + string(c)
-			}
+	defer resp.Body.Close()
 
-		}
+	if resp.StatusCode == 404 {
+		return nil, errors.New("Can't download supported boards.")
+	} else if resp.StatusCode != 200 {
+		return nil, errors.New("HTTP ERROR " + strconv.Itoa(resp.StatusCode) + " (" + SupportedBoardsURL + ")")
+	}
 
-		log.Println("Upgraded")
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
 	}
 
-	time.Sleep(time.Millisecond * 1000)
-	Upgrading = false
-}
+	err = json.Unmarshal(body, &supportedBoards)
+	if err != nil {
+		return nil, err
+	}
 
-func (board *Board) selectSupportedBoard() {
-	var supportedBoards SupportedBoards
+	return supportedBoards, nil
+}
 
+func (board *Board) selectSupportedBoard() error {
 	okayBoards := []string{}
 
 	board.brand = ""
 	board.subtype = ""
 
 	// Get supported boards
-	resp, err := http.Get(SupportedBoardsURL)
-	if err == nil {
-		defer resp.Body.Close()
-
-		if resp.StatusCode == 404 {
-			panic(errors.New("Can't download supported boards."))
-		} else if resp.StatusCode != 200 {
-			panic(errors.New("HTTP ERROR " + strconv.Itoa(resp.StatusCode) + " (" + SupportedBoardsURL + ")"))
-		}
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err == nil {
-			json.Unmarshal(body, &supportedBoards)
+	supportedBoards, err := getSupportedBoards()
+	if err != nil {
+		return classify(exitDownloadFailed, "", err)
+	}
 
-			fmt.Println("\nPlease, enter your board type:\n")
+	fmt.Print("\nPlease, enter your board type:\n\n")
 
-			for option, supportedBoard := range supportedBoards {
-				okayBoards = append(okayBoards, strconv.Itoa(option+1))
-				fmt.Printf("% 3d: %s\n", option+1, supportedBoard.Description)
-			}
-		} else {
-			panic(err)
-		}
-	} else {
-		panic(err)
+	for option, supportedBoard := range supportedBoards {
+		okayBoards = append(okayBoards, strconv.Itoa(option+1))
+		fmt.Printf("% 3d: %s\n", option+1, supportedBoard.Description)
 	}
 
 	fmt.Print("\nType: ")
@@ -952,66 +1052,47 @@ func (board *Board) selectSupportedBoard() {
 	selectedBoard := ""
 
 	_, err = fmt.Scanln(&selectedBoard)
-	if err == nil {
-		if containsString(okayBoards, selectedBoard) {
-			selectedBoard, _ := strconv.Atoi(selectedBoard)
-
-			for option, supportedBoard := range supportedBoards {
-				if option == selectedBoard-1 {
-					board.model = supportedBoard.Type
-					board.brand = supportedBoard.Brand
-					board.subtype = supportedBoard.Subtype
-				}
-			}
-
-			firmware := ""
+	if err != nil || !containsString(okayBoards, selectedBoard) {
+		return classify(exitBadFirmware, "", errors.New("no board type selected"))
+	}
 
-			if board.brand != "" {
-				firmware = board.brand + "-"
-			}
+	option, _ := strconv.Atoi(selectedBoard)
 
-			firmware = firmware + board.model
+	board.model = supportedBoards[option-1].Type
+	board.brand = supportedBoards[option-1].Brand
+	board.subtype = supportedBoards[option-1].Subtype
 
-			if board.subtype != "" {
-				firmware = firmware + "-" + board.subtype
-			}
+	firmware := ""
 
-			board.firmware = firmware
-		}
+	if board.brand != "" {
+		firmware = board.brand + "-"
 	}
-}
 
-func (board *Board) getFirmwareName() string {
-	var supportedBoards SupportedBoards
+	firmware = firmware + board.model
 
-	// Get supported boards
-	resp, err := http.Get(SupportedBoardsURL)
-	if err == nil {
-		defer resp.Body.Close()
+	if board.subtype != "" {
+		firmware = firmware + "-" + board.subtype
+	}
 
-		if resp.StatusCode == 404 {
-			panic(errors.New("Can't download supported boards."))
-		} else if resp.StatusCode != 200 {
-			panic(errors.New("HTTP ERROR " + strconv.Itoa(resp.StatusCode) + " (" + SupportedBoardsURL + ")"))
-		}
+	board.firmware = firmware
 
-		body, err := ioutil.ReadAll(resp.Body)
-		if err == nil {
-			json.Unmarshal(body, &supportedBoards)
+	return nil
+}
 
-			for _, supportedBoard := range supportedBoards {
-				if (supportedBoard.Brand == board.brand) && (supportedBoard.Type == board.model) && (supportedBoard.Subtype == board.subtype) {
-					firmware := supportedBoard.Id
+func (board *Board) getFirmwareName() (string, error) {
+	// Get supported boards
+	supportedBoards, err := getSupportedBoards()
+	if err != nil {
+		return "", err
+	}
 
-					return firmware
-				}
-			}
-		} else {
-			panic(err)
+	for _, supportedBoard := range supportedBoards {
+		if (supportedBoard.Brand == board.brand) && (supportedBoard.Type == board.model) && (supportedBoard.Subtype == board.subtype) {
+			firmware := supportedBoard.Id
+
+			return firmware, nil
 		}
-	} else {
-		panic(err)
 	}
 
-	return ""
+	return "", errors.New("there is not a firmware for " + board.firmware)
 }
@@ -0,0 +1,365 @@
+/*
+ * Whitecat Console, board file transfer tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Open a board on the simulator
+func openSimBoard(t *testing.T) *Board {
+	log.SetOutput(ioutil.Discard)
+	quietProgress = true
+
+	if ConsoleUp == nil {
+		ConsoleUp = make(chan byte, 1024)
+		go console()
+	}
+
+	transport, err := openSimTransport("sim://")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	board := &Board{}
+	board.open(transport, "sim://")
+
+	t.Cleanup(func() {
+		board.setClosed(true)
+		transport.Close()
+	})
+
+	return board
+}
+
+func TestBoardAttach(t *testing.T) {
+	tests := []struct {
+		url    string
+		reset  string
+		resets bool
+		ok     bool
+	}{
+		{"sim://", "rts", true, true},
+		{"sim://", "esptool", true, true},
+		{"sim://", "none", false, true},
+		{"sim://?wiring=rts-inverted", "rts", true, false},
+	}
+
+	openSimBoard(t)
+
+	timeout := config.Timeouts.Boot
+	config.Timeouts.Boot = 2000
+	defer func() { config.Timeouts.Boot = timeout }()
+
+	saved := attachConfig
+	defer func() { attachConfig = saved }()
+
+	for _, test := range tests {
+		sim, err := openSimTransport(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A partial command, that the Ctrl-C sent before waiting for the
+		// prompt discards
+		sim.mu.Lock()
+		boots := sim.boots
+		sim.line = []byte("error(\"not discarded\")")
+		sim.mu.Unlock()
+
+		attachConfig = AttachConfig{BaudRate: 115200, Reset: test.reset, Pulse: 10}
+
+		board := &Board{}
+		err = board.attach(sim, test.url)
+
+		if test.ok != (err == nil) {
+			t.Errorf("%s, %s: error is %v", test.url, test.reset, err)
+		}
+
+		sim.mu.Lock()
+		resets := sim.boots != boots
+		sim.mu.Unlock()
+
+		if resets != test.resets {
+			t.Errorf("%s, %s: board reset is %v, expected %v", test.url, test.reset, resets, test.resets)
+		}
+
+		if atomic.LoadInt32(&board.runtimeErrors) != 0 {
+			t.Errorf("%s, %s: partial command run", test.url, test.reset)
+		}
+
+		if test.ok {
+			if output, err := board.call("print(\"ready\")"); err != nil || output != "ready" {
+				t.Errorf("%s, %s: board not ready, output is %q, %v", test.url, test.reset, output, err)
+			}
+		}
+
+		board.setClosed(true)
+		sim.Close()
+	}
+
+	setConnectedBoard(nil)
+}
+
+func TestBoardWriteReadFile(t *testing.T) {
+	var binary []byte
+	for c := 0; c < 256; c++ {
+		binary = append(binary, byte(c))
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"/empty.lua", []byte{}},
+		{"/small.lua", []byte("print(\"hello\")\n")},
+		{"/chunk.lua", bytes.Repeat([]byte("x"), 255)},
+		{"/chunks.lua", bytes.Repeat([]byte("0123456789\r\n"), 100)},
+		{"/examples/binary.bin", bytes.Repeat(binary, 3)},
+	}
+
+	board := openSimBoard(t)
+
+	for _, test := range tests {
+		if err := board.writeFile(test.name, test.content); err != nil {
+			t.Errorf("can't write %s: %v", test.name, err)
+			continue
+		}
+
+		content, err := board.readFile(test.name)
+		if err != nil {
+			t.Errorf("can't read %s: %v", test.name, err)
+			continue
+		}
+
+		if !bytes.Equal(content, test.content) {
+			t.Errorf("%s: content is %q, expected %q", test.name, content, test.content)
+		}
+	}
+}
+
+func TestBoardReadMissingFile(t *testing.T) {
+	board := openSimBoard(t)
+
+	// The Lua error is detected in the first response, without waiting for
+	// the timeout
+	start := time.Now()
+
+	content, err := board.readFile("/nope.lua")
+	if err == nil {
+		t.Fatalf("content is %q, expected an error", content)
+	}
+
+	if exitCode(err) != exitTransferFailed || !strings.Contains(err.Error(), "/nope.lua: No such file or directory") {
+		t.Errorf("error is %v, exit code %d", err, exitCode(err))
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Millisecond*time.Duration(config.Timeouts.Transfer)/2 {
+		t.Errorf("error returned after %v", elapsed)
+	}
+
+	// A chunk with the size of the error first byte, and that starts like
+	// the error, is read
+	data := append([]byte(luaShellError[:5]+"x"), bytes.Repeat([]byte("-"), int(luaShellError[0])-6)...)
+	if err := board.writeFile("/stdin.txt", data); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := board.readFile("/stdin.txt"); err != nil || !bytes.Equal(content, data) {
+		t.Errorf("content is %q, %v, expected %q", content, err, data)
+	}
+}
+
+func TestBoardCall(t *testing.T) {
+	tests := []struct {
+		code   string
+		output string
+		err    string
+	}{
+		{"print(\"hello\")", "hello", ""},
+		{"assert(os.mkdir(\"/lib\"))", "", ""},
+		{"error(\"boom\")", "", "boom"},
+		{"assert(os.remove(\"/nope\"))", "", "/nope: No such file or directory"},
+		{"os.remove(\"/nope\")", "", "/nope: No such file or directory"},
+		{"os.ls(\"/nope\")", "", "/nope: No such file or directory"},
+		{"unknown()", "", "command not supported by the simulator"},
+	}
+
+	board := openSimBoard(t)
+
+	for _, test := range tests {
+		output, err := board.call(test.code)
+
+		if test.err == "" && err != nil {
+			t.Errorf("%s: failed: %v", test.code, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error is %v, expected %q", test.code, err, test.err)
+		} else if output != test.output {
+			t.Errorf("%s: output is %q, expected %q", test.code, output, test.output)
+		}
+	}
+}
+
+func TestBoardList(t *testing.T) {
+	board := openSimBoard(t)
+
+	tests := []struct {
+		dir   string
+		names []string
+		types []string
+		sizes []int64
+		ok    bool
+	}{
+		{"/", []string{"examples", "system.lua"}, []string{"d", "f"}, []int64{0, 35}, true},
+		{"/examples", []string{"blink.lua"}, []string{"f"}, []int64{38}, true},
+		{"/nope", nil, nil, nil, false},
+	}
+
+	for _, test := range tests {
+		entries, err := board.list(test.dir)
+
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s: entries are %+v, expected an error", test.dir, entries)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: failed: %v", test.dir, err)
+			continue
+		}
+
+		var names, types []string
+		var sizes []int64
+
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+			types = append(types, entry.Type)
+			sizes = append(sizes, entry.Size)
+
+			if entry.modTime().IsZero() {
+				t.Errorf("%s: invalid date %q", entry.Name, entry.Date)
+			}
+		}
+
+		if !reflect.DeepEqual(names, test.names) || !reflect.DeepEqual(types, test.types) || !reflect.DeepEqual(sizes, test.sizes) {
+			t.Errorf("%s: entries are %+v, expected %v %v %v", test.dir, entries, test.names, test.types, test.sizes)
+		}
+	}
+}
+
+func TestBoardRemoveAll(t *testing.T) {
+	tests := []struct {
+		name string
+		fs   []string
+		ok   bool
+	}{
+		{"/examples", []string{"/system.lua"}, true},
+		{"examples/", []string{"/system.lua"}, true},
+		{"/examples/blink.lua", []string{"/examples/", "/system.lua"}, true},
+		{"/system.lua", []string{"/examples/", "/examples/blink.lua"}, true},
+		{"/nope", []string{"/examples/", "/examples/blink.lua", "/system.lua"}, false},
+		{"/", []string{"/examples/", "/examples/blink.lua", "/system.lua"}, false},
+		{"", []string{"/examples/", "/examples/blink.lua", "/system.lua"}, false},
+		{"/examples/..", []string{"/examples/", "/examples/blink.lua", "/system.lua"}, false},
+	}
+
+	for _, test := range tests {
+		board := openSimBoard(t)
+
+		if err := board.removeAll(test.name); test.ok != (err == nil) {
+			t.Errorf("%q: error is %v", test.name, err)
+		}
+
+		if fs := simFiles(board.port.(*simTransport)); !reflect.DeepEqual(fs, test.fs) {
+			t.Errorf("%q: board files are %v, expected %v", test.name, fs, test.fs)
+		}
+	}
+}
+
+func TestParseListing(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(ioutil.Discard)
+
+	response := "d\t-\tJan 02 2017 10:30\tlib\r\n" +
+		"E (1234) wifi: not started\r\n" +
+		"f\t12\tJan 02 2017 10:31\tmain.lua\r\n"
+
+	entries := parseListing(response)
+
+	expected := []fileEntry{
+		{Type: "d", Date: "Jan 02 2017 10:30", Name: "lib"},
+		{Type: "f", Size: 12, Date: "Jan 02 2017 10:31", Name: "main.lua"},
+	}
+
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("entries are %+v, expected %+v", entries, expected)
+	}
+
+	if !strings.Contains(logged.String(), "E (1234) wifi: not started") {
+		t.Errorf("unknown line not logged, log is %q", logged.String())
+	}
+
+	if entries := parseListing(""); len(entries) != 0 || strings.Count(logged.String(), "\n") != 1 {
+		t.Errorf("empty listing is %+v, log is %q", entries, logged.String())
+	}
+}
+
+func TestBoardCopy(t *testing.T) {
+	board := openSimBoard(t)
+	sim := board.port.(*simTransport)
+
+	if err := board.copy("/system.lua", "/examples/boot.lua"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(sim.fs["/examples/boot.lua"].content, sim.fs["/system.lua"].content) {
+		t.Errorf("content is %q", sim.fs["/examples/boot.lua"].content)
+	}
+
+	if err := board.copy("/nope.lua", "/copy.lua"); err == nil {
+		t.Error("missing file copied")
+	}
+
+	// Big files have more time
+	if fileTimeout(1024*1024) <= fileTimeout(0) {
+		t.Errorf("timeout is %d for 1 MB, %d for an empty file", fileTimeout(1024*1024), fileTimeout(0))
+	}
+}
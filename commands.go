@@ -0,0 +1,821 @@
+/*
+ * Whitecat Console, commands
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A console command
+type command struct {
+	// Name, arguments and description, for the usage
+	name        string
+	args        string
+	description string
+
+	// Number of arguments, maxArgs is -1 for any number of arguments
+	minArgs int
+	maxArgs int
+
+	// Command needs a connected board
+	board bool
+
+	// Command can be used with boards without a valid firmware
+	anyFirmware bool
+
+	// Command only reads the board output, so the board is connected
+	// without talking with it
+	passive bool
+
+	// Define the command flags, and return the function that runs the
+	// command with the arguments
+	setup func(fs *flag.FlagSet) func(args []string) error
+}
+
+// Console commands
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:        "ports",
+			description: "list all available serial ports on your computer",
+			setup:       setupPorts,
+		},
+		{
+			name:        "info",
+			description: "show the board information",
+			board:       true,
+			setup:       setupInfo,
+		},
+		{
+			name:        "ls",
+			args:        "[path]",
+			description: "list files present in path, / by default",
+			maxArgs:     1,
+			board:       true,
+			setup:       setupLs,
+		},
+		{
+			name:        "get",
+			args:        "src dst",
+			description: "transfer the source file (board) to destination file (computer)",
+			minArgs:     2,
+			maxArgs:     2,
+			board:       true,
+			setup:       setupGet,
+		},
+		{
+			name:        "put",
+			args:        "src dst",
+			description: "transfer the source file or folder (computer) to destination (board)",
+			minArgs:     2,
+			maxArgs:     2,
+			board:       true,
+			setup:       setupPut,
+		},
+		{
+			name:        "cat",
+			args:        "file ...",
+			description: "show the content of files (board)",
+			minArgs:     1,
+			maxArgs:     -1,
+			board:       true,
+			setup:       setupCat,
+		},
+		{
+			name:        "stat",
+			args:        "path",
+			description: "show the type, size and date of a file or directory (board)",
+			minArgs:     1,
+			maxArgs:     1,
+			board:       true,
+			setup:       setupStat,
+		},
+		{
+			name:        "mkdir",
+			args:        "path ...",
+			description: "create directories (board)",
+			minArgs:     1,
+			maxArgs:     -1,
+			board:       true,
+			setup:       setupMkdir,
+		},
+		{
+			name:        "rm",
+			args:        "path ...",
+			description: "remove files or empty directories (board)",
+			minArgs:     1,
+			maxArgs:     -1,
+			board:       true,
+			setup:       setupRm,
+		},
+		{
+			name:        "mv",
+			args:        "src dst",
+			description: "move or rename a file or directory (board)",
+			minArgs:     2,
+			maxArgs:     2,
+			board:       true,
+			setup:       setupMv,
+		},
+		{
+			name:        "cp",
+			args:        "src dst",
+			description: "copy a file (board)",
+			minArgs:     2,
+			maxArgs:     2,
+			board:       true,
+			setup:       setupCp,
+		},
+		{
+			name:        "touch",
+			args:        "file ...",
+			description: "create empty files (board), if they don't exist",
+			minArgs:     1,
+			maxArgs:     -1,
+			board:       true,
+			setup:       setupTouch,
+		},
+		{
+			name:        "sync",
+			args:        "folder [path]",
+			description: "upload the new and changed files of folder (computer) to path (board), / by default",
+			minArgs:     1,
+			maxArgs:     2,
+			board:       true,
+			setup:       setupSync,
+		},
+		{
+			name:        "backup",
+			args:        "file [path]",
+			description: "save the files in path (board), / by default, in a zip or tar file (computer)",
+			minArgs:     1,
+			maxArgs:     2,
+			board:       true,
+			setup:       setupBackup,
+		},
+		{
+			name:        "restore",
+			args:        "file [path]",
+			description: "restore the files of a zip or tar file (computer) in path (board), / by default",
+			minArgs:     1,
+			maxArgs:     2,
+			board:       true,
+			setup:       setupRestore,
+		},
+		{
+			name:        "flash",
+			description: "flash board with last firmware, and optionally with last filesystem",
+			board:       true,
+			setup:       setupFlash,
+		},
+		{
+			name:        "erase",
+			description: "erase flash board",
+			board:       true,
+			anyFirmware: true,
+			setup:       setupErase,
+		},
+		{
+			name:        "terminal",
+			description: "interactive terminal, press Ctrl-] to exit",
+			board:       true,
+			setup:       setupTerminal,
+		},
+		{
+			name:        "monitor",
+			description: "show the board output until Ctrl-C is pressed",
+			board:       true,
+			passive:     true,
+			setup:       setupMonitor,
+		},
+		{
+			name:        "run",
+			args:        "file",
+			description: "run a Lua script, and show its output until it ends",
+			minArgs:     1,
+			maxArgs:     1,
+			board:       true,
+			setup:       setupRun,
+		},
+		{
+			name:        "eval",
+			args:        "code",
+			description: "run Lua code, and show the result",
+			minArgs:     1,
+			maxArgs:     1,
+			board:       true,
+			setup:       setupEval,
+		},
+	}
+}
+
+// Find a command by name
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+// Show the command usage, with the command flags and the global flags
+func (cmd *command) usage() {
+	fmt.Print("usage: wcc [global flags] " + strings.TrimSpace(cmd.name+" [flags] "+cmd.args) + "\r\n\r\n")
+	fmt.Print(cmd.description + "\r\n")
+
+	own := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	own.SetOutput(os.Stdout)
+	cmd.setup(own)
+
+	hasFlags := false
+	own.VisitAll(func(*flag.Flag) {
+		hasFlags = true
+	})
+
+	if hasFlags {
+		fmt.Print("\r\nflags:\r\n\r\n")
+		own.PrintDefaults()
+	}
+
+	fmt.Print("\r\nglobal flags:\r\n\r\n")
+
+	global := flag.NewFlagSet("wcc", flag.ContinueOnError)
+	global.SetOutput(os.Stdout)
+	globalFlags(global)
+	global.PrintDefaults()
+
+	fmt.Print("\r\n")
+}
+
+func setupPorts(fs *flag.FlagSet) func(args []string) error {
+	asJSON := fs.Bool("json", false, "list the ports in JSON format")
+
+	return func(args []string) error {
+		if !*asJSON {
+			fmt.Print("Available serial ports on your computer:\r\n\r\n")
+		}
+
+		return list_ports(*asJSON)
+	}
+}
+
+// Board information shown by the info command
+type boardInfo struct {
+	Port     string `json:"port"`
+	Model    string `json:"model"`
+	Subtype  string `json:"subtype"`
+	Brand    string `json:"brand"`
+	Firmware string `json:"firmware"`
+	Commit   string `json:"commit"`
+}
+
+func setupInfo(fs *flag.FlagSet) func(args []string) error {
+	asJSON := fs.Bool("json", false, "show the information in JSON format")
+
+	return func(args []string) error {
+		commit, err := connectedBoard.getFirmwareCommit()
+		if err != nil {
+			return err
+		}
+
+		info := boardInfo{
+			Port:     connectedBoard.dev,
+			Model:    connectedBoard.model,
+			Subtype:  connectedBoard.subtype,
+			Brand:    connectedBoard.brand,
+			Firmware: connectedBoard.firmware,
+			Commit:   commit,
+		}
+
+		if *asJSON {
+			out, _ := json.MarshalIndent(info, "", "  ")
+			fmt.Println(string(out))
+
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+		fmt.Fprintln(w, "Port:\t"+info.Port)
+		fmt.Fprintln(w, "Model:\t"+info.Model)
+		fmt.Fprintln(w, "Subtype:\t"+info.Subtype)
+		fmt.Fprintln(w, "Brand:\t"+info.Brand)
+		fmt.Fprintln(w, "Firmware:\t"+info.Firmware)
+		fmt.Fprintln(w, "Commit:\t"+info.Commit)
+
+		return w.Flush()
+	}
+}
+
+func setupLs(fs *flag.FlagSet) func(args []string) error {
+	long := fs.Bool("l", false, "show the type, size and date of each entry")
+	recursive := fs.Bool("R", false, "list the subdirectories too, as a tree")
+	sortBy := fs.String("sort", "name", "sort entries by name, size or date")
+	human := fs.Bool("human", false, "show sizes in K, M or G")
+	asJSON := fs.Bool("json", false, "list the entries in JSON format")
+	raw := fs.Bool("raw", false, "show the os.ls output as is, as the old -ls option")
+
+	return func(args []string) error {
+		var entries []fileEntry
+		var err error
+
+		dir := "/"
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		if *raw && *asJSON {
+			return errors.New("--raw and --json can't be used together")
+		}
+
+		if *raw {
+			response, err := connectedBoard.ls(dir)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(response)
+
+			return nil
+		}
+
+		if *recursive {
+			entries, err = connectedBoard.listTree(dir)
+		} else {
+			entries, err = connectedBoard.list(dir)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := sortEntries(entries, *sortBy); err != nil {
+			return err
+		}
+
+		if *asJSON {
+			out, _ := json.MarshalIndent(entries, "", "  ")
+			fmt.Println(string(out))
+
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+		if *recursive {
+			fmt.Fprintln(w, dir)
+		}
+
+		printEntries(w, entries, "", *recursive, *long, *human)
+
+		return w.Flush()
+	}
+}
+
+// Print directory entries, one per line. Subdirectories entries are printed
+// as a tree, with prefix before the names.
+func printEntries(w io.Writer, entries []fileEntry, prefix string, tree bool, long bool, human bool) {
+	for i, entry := range entries {
+		name := entry.Name
+		if entry.isDir() && !tree {
+			name = name + "/"
+		}
+
+		branch, indent := "", ""
+		if tree {
+			if i == len(entries)-1 {
+				branch, indent = "└── ", "    "
+			} else {
+				branch, indent = "├── ", "│   "
+			}
+		}
+
+		if long {
+			size := formatSize(entry.Size, human)
+			if entry.isDir() {
+				size = "-"
+			}
+
+			fmt.Fprintln(w, entry.Type+"\t"+size+"\t"+entry.Date+"\t"+prefix+branch+name)
+		} else {
+			fmt.Fprintln(w, prefix+branch+name)
+		}
+
+		if tree && len(entry.Entries) > 0 {
+			printEntries(w, entry.Entries, prefix+indent, tree, long, human)
+		}
+	}
+}
+
+func setupGet(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		entry, err := connectedBoard.stat(args[0])
+		if err != nil {
+			return err
+		}
+
+		if entry.isDir() {
+			return errors.New(args[0] + ": Is a directory")
+		}
+
+		file, err := connectedBoard.readFile(boardPath(args[0]))
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(args[1], file, 0755)
+	}
+}
+
+func setupPut(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		info, err := os.Stat(args[0])
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			files, sent, err := connectedBoard.putDir(args[0], boardPath(args[1]))
+			if err != nil {
+				return err
+			}
+
+			notify("progress", "\033[K"+strconv.Itoa(files)+" files sended, "+strconv.Itoa(sent)+" bytes\r\n")
+
+			return nil
+		}
+
+		file, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+
+		return connectedBoard.writeFile(boardPath(args[1]), file)
+	}
+}
+
+func setupCat(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		// Only the file content is written to stdout
+		quietProgress = true
+
+		for _, name := range args {
+			entry, err := connectedBoard.stat(name)
+			if err != nil {
+				return err
+			}
+
+			if entry.isDir() {
+				return errors.New(name + ": Is a directory")
+			}
+
+			file, err := connectedBoard.readFile(boardPath(name))
+			if err != nil {
+				return err
+			}
+
+			os.Stdout.Write(file)
+		}
+
+		return nil
+	}
+}
+
+func setupStat(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		entry, err := connectedBoard.stat(args[0])
+		if err != nil {
+			return err
+		}
+
+		kind := "file"
+		if entry.isDir() {
+			kind = "directory"
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+		fmt.Fprintln(w, "Path:\t"+boardPath(args[0]))
+		fmt.Fprintln(w, "Type:\t"+kind)
+		fmt.Fprintln(w, "Size:\t"+strconv.FormatInt(entry.Size, 10))
+		fmt.Fprintln(w, "Date:\t"+entry.Date)
+
+		return w.Flush()
+	}
+}
+
+func setupMkdir(fs *flag.FlagSet) func(args []string) error {
+	parents := fs.Bool("parents", false, "create the parent directories too, and don't fail if the directory exists")
+
+	return func(args []string) error {
+		known := map[string]bool{}
+
+		for _, name := range args {
+			if *parents {
+				if err := connectedBoard.makeDirs(name, known); err != nil {
+					return err
+				}
+			} else if err := connectedBoard.mkdir(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func setupRm(fs *flag.FlagSet) func(args []string) error {
+	recursive := fs.Bool("r", false, "remove directories and their content")
+
+	return func(args []string) error {
+		for _, name := range args {
+			if *recursive {
+				if err := connectedBoard.removeAll(name); err != nil {
+					return err
+				}
+			} else if err := connectedBoard.remove(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Get the destination path of a move or copy. If dst is a directory, src
+// is moved or copied into it.
+func destinationPath(src string, dst string) (string, error) {
+	entry, found, err := connectedBoard.find(dst)
+	if err != nil {
+		return "", err
+	}
+
+	if found && entry.isDir() {
+		return path.Join(dst, path.Base(src)), nil
+	}
+
+	return dst, nil
+}
+
+func setupMv(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		dst, err := destinationPath(args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		return connectedBoard.rename(args[0], dst)
+	}
+}
+
+func setupCp(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		dst, err := destinationPath(args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		return connectedBoard.copy(args[0], dst)
+	}
+}
+
+func setupTouch(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		for _, name := range args {
+			if err := connectedBoard.touch(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func setupSync(fs *flag.FlagSet) func(args []string) error {
+	var options syncOptions
+
+	fs.BoolVar(&options.checksum, "checksum", false, "compare the files using a checksum computed by the board, instead of the size and date")
+	fs.BoolVar(&options.delete, "delete", false, "remove the files in the board that don't exist in folder")
+	fs.BoolVar(&options.dryRun, "dry-run", false, "show the changes, without doing them")
+
+	return func(args []string) error {
+		dir := "/"
+		if len(args) > 1 {
+			dir = args[1]
+		}
+
+		info, err := os.Stat(args[0])
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return errors.New(args[0] + " is not a folder")
+		}
+
+		result, err := connectedBoard.syncDir(args[0], dir, options)
+		if err != nil {
+			return err
+		}
+
+		notify("progress", "\033[K"+result.String()+"\r\n")
+
+		return nil
+	}
+}
+
+func setupBackup(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		dir := "/"
+		if len(args) > 1 {
+			dir = args[1]
+		}
+
+		files, saved, err := connectedBoard.backup(dir, args[0])
+		if err != nil {
+			return err
+		}
+
+		notify("progress", "\033[K"+strconv.Itoa(files)+" files saved in "+args[0]+", "+strconv.Itoa(saved)+" bytes\r\n")
+
+		return nil
+	}
+}
+
+func setupRestore(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		dir := "/"
+		if len(args) > 1 {
+			dir = args[1]
+		}
+
+		files, restored, err := connectedBoard.restore(args[0], dir)
+		if err != nil {
+			return err
+		}
+
+		notify("progress", "\033[K"+strconv.Itoa(files)+" files restored from "+args[0]+", "+strconv.Itoa(restored)+" bytes\r\n")
+
+		return nil
+	}
+}
+
+func setupFlash(fs *flag.FlagSet) func(args []string) error {
+	withFS := fs.Bool("fs", false, "flash the filesystem too")
+	onlyFS := fs.Bool("fs-only", false, "flash only the filesystem")
+
+	return func(args []string) error {
+		flash := !*onlyFS
+		flashFS := *withFS || *onlyFS
+
+		newBuild := false
+
+		commit, err := connectedBoard.getFirmwareCommit()
+		if err != nil {
+			return err
+		}
+
+		lastCommit := ""
+
+		// Test for a new firmware version
+		resp, err := http.Get(LastBuildURL + "?firmware=" + connectedBoard.firmware)
+		if err != nil {
+			return classify(exitDownloadFailed, "", err)
+		}
+
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return classify(exitDownloadFailed, "", err)
+		}
+
+		lastCommit = string(body)
+
+		log.Println("current commit ", commit)
+		log.Println("last commit ", lastCommit)
+
+		if (commit != lastCommit) && (lastCommit != "") {
+			newBuild = true
+			notify("progress", "new firmware available "+commit+"\r\n")
+		} else {
+			notify("progress", "board is updated "+commit+"\r\n")
+		}
+
+		if newBuild || flashFS {
+			if err = connectedBoard.upgrade(false, newBuild && flash, flashFS); err != nil {
+				return err
+			}
+
+			notify("progress", "board upgraded to "+lastCommit+"\r\n")
+		}
+
+		return nil
+	}
+}
+
+func setupErase(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if err := connectedBoard.upgrade(true, false, false); err != nil {
+			return err
+		}
+
+		notify("progress", "Board erased           \r\n")
+
+		return nil
+	}
+}
+
+func setupTerminal(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		terminal()
+
+		return nil
+	}
+}
+
+func setupRun(fs *flag.FlagSet) func(args []string) error {
+	timeout := fs.Int("timeout", 0, "interrupt the script after timeout seconds, 0 for no timeout")
+
+	return func(args []string) error {
+		if *timeout < 0 {
+			return fmt.Errorf("invalid timeout %d", *timeout)
+		}
+
+		return connectedBoard.runScript(args[0], *timeout)
+	}
+}
+
+func setupEval(fs *flag.FlagSet) func(args []string) error {
+	asJSON := fs.Bool("json", false, "show the result in JSON format")
+
+	return func(args []string) error {
+		if !*asJSON {
+			// Output is shown even if the code fails
+			response, err := connectedBoard.eval(args[0])
+			if response != "" || err == nil {
+				fmt.Println(response)
+			}
+
+			return err
+		}
+
+		result, err := connectedBoard.evalJSON(args[0])
+		if err != nil {
+			return err
+		}
+
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+
+		// The error is in the result, so it's not shown again
+		if !result.Ok {
+			return classify(exitFailure, "", errReported)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * Whitecat Console, user configuration
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Name of the configuration file, in the user data folder
+const configFileName = "wcc.yml"
+
+// User configuration. Values not present in the configuration file keep
+// their default value.
+type Config struct {
+	// Default port, baud rate and board type
+	Port  string `yaml:"port"`
+	Baud  int    `yaml:"baud"`
+	Board string `yaml:"board"`
+
+	// Mirror URLs for download the firmware
+	URLs struct {
+		LastBuild       string `yaml:"lastBuild"`
+		Firmware        string `yaml:"firmware"`
+		SupportedBoards string `yaml:"supportedBoards"`
+		Esptool         string `yaml:"esptool"`
+	} `yaml:"urls"`
+
+	// Timeouts, in milliseconds
+	Timeouts struct {
+		Command  int `yaml:"command"`
+		Transfer int `yaml:"transfer"`
+		Boot     int `yaml:"boot"`
+	} `yaml:"timeouts"`
+
+	// Port aliases, for example lab1: /dev/ttyUSB0
+	Aliases map[string]string `yaml:"aliases"`
+
+	// Attach configuration for board types, used with the board option
+	Boards map[string]AttachConfig `yaml:"boards"`
+}
+
+// Current configuration
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	var c Config
+
+	c.Timeouts.Command = 2000
+	c.Timeouts.Transfer = 2000
+	c.Timeouts.Boot = 4000
+
+	c.Aliases = map[string]string{}
+
+	return c
+}
+
+// Get the path of the configuration file. The WCC_CONFIG environment
+// variable can be used for use another file.
+func configFile() string {
+	if file := os.Getenv("WCC_CONFIG"); file != "" {
+		return file
+	}
+
+	return path.Join(AppDataFolder, configFileName)
+}
+
+// Load the configuration file, if exists, and apply the environment
+// variable overrides
+func loadConfig() error {
+	file := configFile()
+
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		err = yaml.Unmarshal(data, &config)
+		if err == nil {
+			err = config.check()
+		}
+
+		if err != nil {
+			return errors.New(file + ": " + err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	config.lowerAliases()
+
+	err = config.applyEnv()
+	if err != nil {
+		return err
+	}
+
+	config.apply()
+
+	return nil
+}
+
+// Check the values of the configuration file
+func (c *Config) check() error {
+	keys := []string{"timeouts.command", "timeouts.transfer", "timeouts.boot"}
+	timeouts := []int{c.Timeouts.Command, c.Timeouts.Transfer, c.Timeouts.Boot}
+
+	for i, value := range timeouts {
+		if value <= 0 {
+			return errors.New("invalid value for " + keys[i] + ": " + strconv.Itoa(value) + ", it must be greater than 0")
+		}
+	}
+
+	if c.Baud < 0 {
+		return errors.New("invalid value for baud: " + strconv.Itoa(c.Baud))
+	}
+
+	return nil
+}
+
+// Override the configuration with the WCC_* environment variables. Port
+// aliases are set with WCC_ALIAS_<name>.
+func (c *Config) applyEnv() error {
+	texts := map[string]*string{
+		"WCC_PORT":                 &c.Port,
+		"WCC_BOARD":                &c.Board,
+		"WCC_LAST_BUILD_URL":       &c.URLs.LastBuild,
+		"WCC_FIRMWARE_URL":         &c.URLs.Firmware,
+		"WCC_SUPPORTED_BOARDS_URL": &c.URLs.SupportedBoards,
+		"WCC_ESPTOOL_URL":          &c.URLs.Esptool,
+	}
+
+	ints := map[string]*int{
+		"WCC_BAUD":             &c.Baud,
+		"WCC_TIMEOUT_COMMAND":  &c.Timeouts.Command,
+		"WCC_TIMEOUT_TRANSFER": &c.Timeouts.Transfer,
+		"WCC_TIMEOUT_BOOT":     &c.Timeouts.Boot,
+	}
+
+	for name, value := range texts {
+		if env := os.Getenv(name); env != "" {
+			*value = env
+		}
+	}
+
+	for name, value := range ints {
+		if env := os.Getenv(name); env != "" {
+			// Timeouts must be positive, with 0 every read times out
+			n, err := strconv.Atoi(env)
+			if err != nil || n < 0 || (n == 0 && strings.HasPrefix(name, "WCC_TIMEOUT_")) {
+				return errors.New("invalid value for " + name + ": " + env)
+			}
+
+			*value = n
+		}
+	}
+
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "WCC_ALIAS_") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(env, "WCC_ALIAS_"), "=", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			c.Aliases[strings.ToLower(parts[0])] = parts[1]
+		}
+	}
+
+	return nil
+}
+
+// Apply the configuration to the URLs and to the defaults of the global
+// flags, so command line flags have precedence
+func (c *Config) apply() {
+	if c.URLs.LastBuild != "" {
+		LastBuildURL = c.URLs.LastBuild
+	}
+
+	if c.URLs.Firmware != "" {
+		FirmwareURL = c.URLs.Firmware
+	}
+
+	if c.URLs.SupportedBoards != "" {
+		SupportedBoardsURL = c.URLs.SupportedBoards
+	}
+
+	if c.URLs.Esptool != "" {
+		EsptoolURL = c.URLs.Esptool
+	}
+
+	// Values not present in a board type are taken from the default
+	// attach configuration
+	for name, board := range c.Boards {
+		if board.BaudRate == 0 {
+			board.BaudRate = defaultAttachConfig.BaudRate
+		}
+
+		if board.Reset == "" {
+			board.Reset = defaultAttachConfig.Reset
+		}
+
+		if board.Pulse == 0 {
+			board.Pulse = defaultAttachConfig.Pulse
+		}
+
+		// A board type of the configuration file replaces the built-in
+		// one with the same name
+		for builtIn := range boardAttachConfigs {
+			if strings.EqualFold(builtIn, name) {
+				delete(boardAttachConfigs, builtIn)
+			}
+		}
+
+		boardAttachConfigs[name] = board
+	}
+
+	flagPort = c.Port
+	flagBaud = c.Baud
+	flagBoard = c.Board
+}
+
+// Lowercase the alias names of the configuration file, because aliases are
+// case insensitive, as the aliases set with environment variables
+func (c *Config) lowerAliases() {
+	aliases := map[string]string{}
+
+	for name, port := range c.Aliases {
+		aliases[strings.ToLower(name)] = port
+	}
+
+	c.Aliases = aliases
+}
+
+// Get the port for a port name, that can be an alias. Alias names are case
+// insensitive.
+func (c *Config) resolvePort(name string) string {
+	if port, found := c.Aliases[strings.ToLower(name)]; found {
+		return port
+	}
+
+	return name
+}
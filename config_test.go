@@ -0,0 +1,149 @@
+/*
+ * Whitecat Console, configuration tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"testing"
+)
+
+func TestConfigApplyEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected func(c *Config)
+		ok       bool
+	}{
+		{
+			"no variables",
+			map[string]string{},
+			func(c *Config) {},
+			true,
+		},
+		{
+			"texts",
+			map[string]string{"WCC_PORT": "/dev/ttyUSB1", "WCC_BOARD": "thing", "WCC_ESPTOOL_URL": "http://mirror/esptool"},
+			func(c *Config) {
+				c.Port = "/dev/ttyUSB1"
+				c.Board = "thing"
+				c.URLs.Esptool = "http://mirror/esptool"
+			},
+			true,
+		},
+		{
+			"numbers",
+			map[string]string{"WCC_BAUD": "921600", "WCC_TIMEOUT_COMMAND": "500", "WCC_TIMEOUT_BOOT": "8000"},
+			func(c *Config) {
+				c.Baud = 921600
+				c.Timeouts.Command = 500
+				c.Timeouts.Boot = 8000
+			},
+			true,
+		},
+		{
+			"aliases",
+			map[string]string{"WCC_ALIAS_LAB1": "/dev/ttyUSB0", "WCC_ALIAS_": "/dev/ttyUSB1"},
+			func(c *Config) {
+				c.Aliases["lab1"] = "/dev/ttyUSB0"
+			},
+			true,
+		},
+		{"baud 0", map[string]string{"WCC_BAUD": "0"}, func(c *Config) {}, true},
+		{"invalid baud", map[string]string{"WCC_BAUD": "fast"}, nil, false},
+		{"negative baud", map[string]string{"WCC_BAUD": "-1"}, nil, false},
+		{"timeout 0", map[string]string{"WCC_TIMEOUT_TRANSFER": "0"}, nil, false},
+		{"negative timeout", map[string]string{"WCC_TIMEOUT_COMMAND": "-100"}, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			c := defaultConfig()
+			err := c.applyEnv()
+
+			if !test.ok {
+				if err == nil {
+					t.Errorf("configuration is %+v, expected an error", c)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := defaultConfig()
+			test.expected(&expected)
+
+			if !reflect.DeepEqual(c, expected) {
+				t.Errorf("configuration is %+v, expected %+v", c, expected)
+			}
+		})
+	}
+}
+
+func TestConfigResolvePort(t *testing.T) {
+	t.Setenv("WCC_ALIAS_LAB2", "rfc2217://192.168.1.10:4000")
+
+	c := defaultConfig()
+
+	if err := yaml.Unmarshal([]byte("aliases:\n  Lab1: /dev/ttyUSB0\n"), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.lowerAliases()
+
+	if err := c.applyEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		port string
+	}{
+		{"lab1", "/dev/ttyUSB0"},
+		{"Lab1", "/dev/ttyUSB0"},
+		{"LAB1", "/dev/ttyUSB0"},
+		{"lab2", "rfc2217://192.168.1.10:4000"},
+		{"Lab2", "rfc2217://192.168.1.10:4000"},
+		{"/dev/ttyUSB1", "/dev/ttyUSB1"},
+	}
+
+	for _, test := range tests {
+		if port := c.resolvePort(test.name); port != test.port {
+			t.Errorf("%s: port is %s, expected %s", test.name, port, test.port)
+		}
+	}
+}
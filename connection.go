@@ -30,9 +30,10 @@
 package main
 
 import (
-	"fmt"
-	"github.com/mikepb/go-serial"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // This channel is used by the create agent for send console output
@@ -41,25 +42,60 @@ var ConsoleUp chan byte
 // Connected board
 var connectedBoard *Board = nil
 
+// Guards connectedBoard, that is changed by the reconnect goroutine while
+// the terminal or the monitor are running
+var connectedBoardMutex sync.Mutex
+
+// Get the connected board
+func currentBoard() *Board {
+	connectedBoardMutex.Lock()
+	defer connectedBoardMutex.Unlock()
+
+	return connectedBoard
+}
+
+// Set the connected board
+func setConnectedBoard(board *Board) {
+	connectedBoardMutex.Lock()
+	defer connectedBoardMutex.Unlock()
+
+	connectedBoard = board
+}
+
+// Console modes, that tell where the board's console output is written
+const (
+	consoleModeNone = iota
+	consoleModeTerminal
+	consoleModeMonitor
+)
+
+// Current console mode, accessed atomically because it's read by the
+// console goroutine
+var consoleMode int32
+
+func setConsoleMode(mode int32) {
+	atomic.StoreInt32(&consoleMode, mode)
+}
+
 // This function consumes all chars in ConsoleUp channel.
 // This is need for minimize changes in Whitecat Create Agent sources.
+// In terminal and monitor modes chars are written to stdout.
 func console() {
 	for {
-		<-ConsoleUp
-	}
-}
+		c := <-ConsoleUp
 
-func connect(port string) {
-	defer func() {
-		if err := recover(); err != nil {
-			if err.(error).Error() != "timeout" {
-				panic(err)
-			}
-		} else {
-			log.Println("board attached")
+		switch atomic.LoadInt32(&consoleMode) {
+		case consoleModeTerminal:
+			terminalOutput(c)
+		case consoleModeMonitor:
+			monitorOutput(c)
 		}
-	}()
+	}
+}
 
+// Connect to the board at port. If the board doesn't answer, it's attached
+// anyway as a board without a valid firmware.
+func connect(port string) error {
 	log.Println("connecting to board on", port, "...")
 
 	ConsoleUp = make(chan byte, 1024)
@@ -67,16 +103,20 @@ func connect(port string) {
 	go console()
 
 	// Open port
-	info, err := serial.PortByName(port)
+	transport, err := openTransport(port, attachConfig.BaudRate)
 	if err != nil {
-		return
+		return classify(exitNoPort, "", err)
 	}
 
 	// Create a candidate board
 	var candidate Board
 
 	// Attach candidate
-	candidate.attach(info)
+	err = candidate.attach(transport, port)
+	if err != nil && err != errTimeout {
+		return err
+	}
+
 	if connectedBoard != nil {
 		if connectedBoard.validFirmware {
 			connectedBoard.port.Write([]byte("os.shell(false)\r\n"))
@@ -84,17 +124,116 @@ func connect(port string) {
 		}
 	}
 
-	return
+	return nil
 }
 
-func list_ports() {
-	// Enumerate all serial ports
-	ports, err := serial.ListPorts()
+// Connect to the board at port without talking with the board, so its
+// output is not disturbed. The board is reset, unless the reset sequence
+// is none.
+func connectPassive(port string) error {
+	log.Println("connecting to board on", port, "...")
+
+	ConsoleUp = make(chan byte, 1024)
+
+	go console()
+
+	// Open port
+	transport, err := openTransport(port, attachConfig.BaudRate)
 	if err != nil {
-		return
+		return classify(exitNoPort, "", err)
+	}
+
+	board := &Board{}
+	board.open(transport, port)
+
+	// The board is not queried, so the firmware is taken from the board type
+	board.firmware = attachConfig.Firmware
+
+	preloadSymbolizer(board.firmware)
+
+	if transport.HasControlLines() && attachConfig.Reset != "none" {
+		attachConfig.resetPort(transport)
 	}
 
-	for _, info := range ports {
-		fmt.Println(info.Name())
+	setConnectedBoard(board)
+
+	notify("boardAttached", "")
+
+	return nil
+}
+
+// Reopen the port of a detached board, retrying until the board is found.
+// USB boards are found by its serial number, because the port name can
+// change when the board is reconnected. The board is not reset. Returns
+// false if stop is closed while waiting.
+func reconnect(stop <-chan struct{}) bool {
+	old := currentBoard()
+
+	old.setClosed(true)
+	old.port.Close()
+
+	for {
+		select {
+		case <-stop:
+			return false
+
+		case <-time.After(time.Millisecond * 500):
+			dev := old.dev
+
+			if old.serialNumber != "" {
+				dev = findPortBySerialNumber(old.serialNumber)
+				if dev == "" {
+					continue
+				}
+			}
+
+			transport, err := openTransport(dev, attachConfig.BaudRate)
+			if err != nil {
+				log.Println("can't reopen", dev, ":", err)
+				continue
+			}
+
+			board := &Board{}
+			board.open(transport, dev)
+
+			board.model = old.model
+			board.subtype = old.subtype
+			board.brand = old.brand
+			board.firmware = old.firmware
+			board.validFirmware = old.validFirmware
+
+			setConnectedBoard(board)
+
+			log.Println("board reconnected at", dev)
+
+			notify("boardAttached", "")
+
+			return true
+		}
+	}
+}
+
+// Keep the board connected until stop is closed. When the board is
+// detached, it's reconnected. Changes are reported using the status
+// function.
+func keepConnected(stop <-chan struct{}, status func(string)) {
+	for {
+		select {
+		case <-stop:
+			return
+
+		case <-currentBoard().quit:
+			if currentBoard().isClosed() {
+				return
+			}
+
+			status("board disconnected, waiting for it ...")
+
+			if !reconnect(stop) {
+				return
+			}
+
+			status("board reconnected at " + currentBoard().dev)
+		}
 	}
 }
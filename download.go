@@ -69,7 +69,6 @@ func unzip(src, dest string) error {
 
 			err = os.MkdirAll(fdir, 0777)
 			if err != nil {
-				log.Fatal(err)
 				return err
 			}
 			f, err := os.OpenFile(
@@ -92,7 +91,7 @@ func unzip(src, dest string) error {
 func downloadEsptool() error {
 	notify("boardUpdate", "Downloading esptool")
 
-	url := "http://downloads.whitecatboard.org/esptool/esptool-" + runtime.GOOS + ".zip"
+	url := EsptoolURL + "/esptool-" + runtime.GOOS + ".zip"
 
 	log.Println("downloading esptool from " + url + " ...")
 
@@ -101,9 +100,9 @@ func downloadEsptool() error {
 		defer resp.Body.Close()
 
 		if resp.StatusCode == 404 {
-			panic(errors.New("Can't download esptool."))
+			return errors.New("Can't download esptool.")
 		} else if resp.StatusCode != 200 {
-			panic(errors.New("HTTP ERROR " + strconv.Itoa(resp.StatusCode) + " (" + url + ")"))
+			return errors.New("HTTP ERROR " + strconv.Itoa(resp.StatusCode) + " (" + url + ")")
 		}
 
 		body, err := ioutil.ReadAll(resp.Body)
@@ -116,7 +115,10 @@ func downloadEsptool() error {
 
 				log.Println("unpacking esptool ...")
 
-				unzip(path.Join(AppDataTmpFolder, "esptool.zip"), path.Join(AppDataTmpFolder, "utils"))
+				err = unzip(path.Join(AppDataTmpFolder, "esptool.zip"), path.Join(AppDataTmpFolder, "utils"))
+				if err != nil {
+					return err
+				}
 			} else {
 				return err
 			}
@@ -142,9 +144,9 @@ func downloadFirmware(firmware string) error {
 		defer resp.Body.Close()
 
 		if resp.StatusCode == 404 {
-			panic(errors.New("Can't download firmware, or is not yet available in official builds."))
+			return errors.New("Can't download firmware, or is not yet available in official builds.")
 		} else if resp.StatusCode != 200 {
-			panic(errors.New("HTTP ERROR " + strconv.Itoa(resp.StatusCode) + " (" + url + ")"))
+			return errors.New("HTTP ERROR " + strconv.Itoa(resp.StatusCode) + " (" + url + ")")
 		}
 
 		body, err := ioutil.ReadAll(resp.Body)
@@ -155,7 +157,14 @@ func downloadFirmware(firmware string) error {
 
 				log.Println("unpacking firmware ...")
 
-				unzip(path.Join(AppDataTmpFolder, "firmware.zip"), path.Join(AppDataTmpFolder, "firmware_files"))
+				err = unzip(path.Join(AppDataTmpFolder, "firmware.zip"), path.Join(AppDataTmpFolder, "firmware_files"))
+				if err != nil {
+					return err
+				}
+
+				if err := cacheFirmwareELF(firmware, path.Join(AppDataTmpFolder, "firmware_files")); err != nil {
+					log.Println("can't cache ELF files:", err)
+				}
 			} else {
 				return err
 			}
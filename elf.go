@@ -0,0 +1,300 @@
+/*
+ * Whitecat Console, ELF symbols and DWARF line information
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// An entry of the DWARF line table
+type lineEntry struct {
+	address     uint64
+	file        string
+	line        int
+	endSequence bool
+}
+
+// A function symbol
+type funcSymbol struct {
+	name    string
+	address uint64
+	size    uint64
+}
+
+// Decode code addresses to function, file and line, using the symbols and
+// the DWARF information of an ELF file
+type symbolizer struct {
+	lines []lineEntry
+	funcs []funcSymbol
+}
+
+// Load the symbols and the line table of an ELF file
+func loadSymbolizer(file string) (*symbolizer, error) {
+	f, err := elf.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	s := &symbolizer{}
+
+	symbols, err := f.Symbols()
+	if err == nil {
+		for _, symbol := range symbols {
+			if elf.ST_TYPE(symbol.Info) == elf.STT_FUNC && symbol.Value != 0 {
+				s.funcs = append(s.funcs, funcSymbol{symbol.Name, symbol.Value, symbol.Size})
+			}
+		}
+	}
+
+	sort.Slice(s.funcs, func(i, j int) bool {
+		return s.funcs[i].address < s.funcs[j].address
+	})
+
+	data, err := f.DWARF()
+	if err != nil {
+		// Without DWARF information only function names are decoded
+		log.Println("no DWARF information in", file, ":", err)
+
+		return s, nil
+	}
+
+	reader := data.Reader()
+
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if entry == nil {
+			break
+		}
+
+		if entry.Tag != dwarf.TagCompileUnit {
+			reader.SkipChildren()
+			continue
+		}
+
+		lr, err := data.LineReader(entry)
+		if err != nil || lr == nil {
+			continue
+		}
+
+		var row dwarf.LineEntry
+
+		for {
+			if err := lr.Next(&row); err != nil {
+				if err != io.EOF {
+					log.Println("can't read line table:", err)
+				}
+
+				break
+			}
+
+			file := ""
+			if row.File != nil {
+				file = row.File.Name
+			}
+
+			s.lines = append(s.lines, lineEntry{row.Address, file, row.Line, row.EndSequence})
+		}
+
+		// The line table has all the information needed from the unit
+		reader.SkipChildren()
+	}
+
+	sort.SliceStable(s.lines, func(i, j int) bool {
+		return s.lines[i].address < s.lines[j].address
+	})
+
+	return s, nil
+}
+
+// Get the function, file and line for a code address. Returns empty values
+// if the address is unknown.
+func (s *symbolizer) lookup(address uint64) (function string, file string, line int) {
+	i := sort.Search(len(s.funcs), func(i int) bool {
+		return s.funcs[i].address > address
+	}) - 1
+
+	if i >= 0 {
+		f := s.funcs[i]
+		if address < f.address+f.size || (f.size == 0 && address == f.address) {
+			function = f.name
+		}
+	}
+
+	i = sort.Search(len(s.lines), func(i int) bool {
+		return s.lines[i].address > address
+	}) - 1
+
+	if i >= 0 && !s.lines[i].endSequence {
+		file = s.lines[i].file
+		line = s.lines[i].line
+	}
+
+	return function, file, line
+}
+
+// Folder where the ELF files of the firmwares are cached
+func firmwareCacheFolder(firmware string) string {
+	return path.Join(AppDataFolder, "firmware", firmware)
+}
+
+// Find the ELF file of a firmware. If a file is set with the -elf flag this
+// file is used, if not the firmware cache is used.
+func findFirmwareELF(firmware string) string {
+	if flagELF != "" {
+		return flagELF
+	}
+
+	if firmware == "" {
+		return ""
+	}
+
+	files, _ := filepath.Glob(path.Join(firmwareCacheFolder(firmware), "lua_rtos.*.elf"))
+	if len(files) > 0 {
+		return files[0]
+	}
+
+	return ""
+}
+
+// A symbolizer loaded in the background, done is closed when the load ends
+type symbolizerLoad struct {
+	done chan struct{}
+	s    *symbolizer
+}
+
+// Symbolizer loads, in progress or ended, by ELF file. Failed loads are
+// removed, so they are retried the next time.
+var (
+	symbolizerLock  sync.Mutex
+	symbolizerLoads = map[string]*symbolizerLoad{}
+)
+
+// Start loading the symbolizer for a firmware in the background, if there
+// is an ELF file for the firmware and it isn't loaded yet. Returns the load,
+// or nil if there isn't an ELF file for the firmware.
+func preloadSymbolizer(firmware string) *symbolizerLoad {
+	file := findFirmwareELF(firmware)
+	if file == "" {
+		return nil
+	}
+
+	symbolizerLock.Lock()
+	defer symbolizerLock.Unlock()
+
+	if load, ok := symbolizerLoads[file]; ok {
+		return load
+	}
+
+	load := &symbolizerLoad{done: make(chan struct{})}
+	symbolizerLoads[file] = load
+
+	go func() {
+		defer close(load.done)
+
+		s, err := loadSymbolizer(file)
+		if err != nil {
+			log.Println("can't load", file, ":", err)
+
+			symbolizerLock.Lock()
+			delete(symbolizerLoads, file)
+			symbolizerLock.Unlock()
+
+			return
+		}
+
+		log.Println("symbols loaded from", file)
+
+		load.s = s
+	}()
+
+	return load
+}
+
+// Get the symbolizer for a firmware, or nil if there isn't an ELF file for
+// the firmware or it isn't loaded yet. It never waits for the load, because
+// it's used by the inspector, that can't stop reading the board.
+func getSymbolizer(firmware string) *symbolizer {
+	load := preloadSymbolizer(firmware)
+	if load == nil {
+		return nil
+	}
+
+	select {
+	case <-load.done:
+		return load.s
+	default:
+		log.Println("symbols are not loaded yet")
+		return nil
+	}
+}
+
+// Copy the ELF files of a downloaded firmware to the firmware cache, so they
+// can be used later for decode the panics of the board
+func cacheFirmwareELF(firmware string, folder string) error {
+	files, err := filepath.Glob(path.Join(folder, "*.elf"))
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	cache := firmwareCacheFolder(firmware)
+	if err := os.MkdirAll(cache, 0755); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(path.Join(cache, filepath.Base(file)), content, 0644); err != nil {
+			return err
+		}
+
+		log.Println("cached", filepath.Base(file), "in", cache)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+ * Whitecat Console, ELF symbols tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The test binary is an ELF file with DWARF information, so it's used for
+// test the symbolizer
+func testELF(t *testing.T) string {
+	file, err := os.Executable()
+	if err != nil {
+		t.Skip("can't find the test binary:", err)
+	}
+
+	return file
+}
+
+func TestLoadSymbolizer(t *testing.T) {
+	s, err := loadSymbolizer(testELF(t))
+	if err != nil {
+		t.Skip("the test binary is not an ELF file:", err)
+	}
+
+	// go test strips the symbols, unless the binary is built with go test -c
+	if len(s.funcs) == 0 {
+		t.Skip("the test binary has no symbols")
+	}
+
+	address := uint64(reflect.ValueOf(TestLoadSymbolizer).Pointer())
+
+	function, file, line := s.lookup(address)
+	if !strings.HasSuffix(function, ".TestLoadSymbolizer") {
+		t.Errorf("function is %q", function)
+	}
+
+	if len(s.lines) > 0 && (!strings.HasSuffix(file, "elf_test.go") || line == 0) {
+		t.Errorf("file and line are %q, %d", file, line)
+	}
+
+	if function, file, line := s.lookup(0); function != "" || file != "" || line != 0 {
+		t.Errorf("address 0 is %q, %q, %d", function, file, line)
+	}
+}
+
+func TestPreloadSymbolizer(t *testing.T) {
+	old := flagELF
+	defer func() {
+		flagELF = old
+	}()
+
+	flagELF = ""
+	if load := preloadSymbolizer(""); load != nil {
+		t.Error("symbolizer without ELF file")
+	}
+
+	flagELF = "/nonexistent/lua_rtos.elf"
+	if load := preloadSymbolizer(""); load != nil {
+		<-load.done
+		if load.s != nil || getSymbolizer("") != nil {
+			t.Error("symbolizer for a missing ELF file")
+		}
+	}
+
+	flagELF = testELF(t)
+
+	load := preloadSymbolizer("")
+	if load == nil {
+		t.Fatal("symbolizer not loaded")
+	}
+
+	<-load.done
+	if load.s == nil {
+		t.Skip("the test binary is not an ELF file")
+	}
+
+	if preloadSymbolizer("") != load {
+		t.Error("symbolizer loaded again")
+	}
+
+	if getSymbolizer("") != load.s {
+		t.Error("loaded symbolizer not returned")
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Whitecat Console, errors and exit codes
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"errors"
+)
+
+// Exit codes of the console. Each failure class has its own exit code, so
+// the console can be used from scripts.
+const (
+	exitOk             = 0
+	exitFailure        = 1
+	exitNoPort         = 2
+	exitTimeout        = 3
+	exitBadFirmware    = 4
+	exitTransferFailed = 5
+	exitDownloadFailed = 6
+	exitFlashFailed    = 7
+)
+
+// An error with the exit code of its failure class
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// Timeout waiting for data from the board
+var errTimeout = &exitError{exitTimeout, errors.New("timeout")}
+
+// Board was disconnected while waiting for data from the board
+var errDetached = &exitError{exitNoPort, errors.New("board detached")}
+
+// The failure was already reported in the output of the command, for
+// example in a JSON result, so it's not shown
+var errReported = errors.New("failure already reported")
+
+// Set the failure class of err, using message as the error message. If
+// message is empty the message of err is used.
+func classify(code int, message string, err error) error {
+	if message != "" {
+		err = errors.New(message + ": " + err.Error())
+	}
+
+	return &exitError{code, err}
+}
+
+// Test if err was already reported in the output of the command
+func isReported(err error) bool {
+	if e, ok := err.(*exitError); ok {
+		return e.err == errReported
+	}
+
+	return err == errReported
+}
+
+// Get the exit code for err. Errors without a failure class are general
+// failures.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOk
+	}
+
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+
+	return exitFailure
+}
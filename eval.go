@@ -0,0 +1,218 @@
+/*
+ * Whitecat Console, Lua code evaluation
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lua snippet that defines the wcc_json function, that serializes a Lua
+// value to JSON, and the wcc_eval function, that runs Lua code and prints
+// the result as a JSON object. Code is evaluated as an expression if
+// possible, or as a statement if not. The snippet is sent in one line, so
+// it can't contain comments.
+const luaEvalJSON = `
+local function wcc_json(v, depth)
+	local t = type(v)
+	depth = depth or 0
+	if t == "nil" then
+		return "null"
+	elseif t == "boolean" then
+		return tostring(v)
+	elseif t == "number" then
+		if v ~= v or v == math.huge or v == -math.huge then
+			return "null"
+		elseif math.type(v) == "integer" then
+			return string.format("%d", v)
+		end
+		return string.format("%.14g", v)
+	elseif t == "string" then
+		return '"' .. (v:gsub('[%c"\\]', function(c) return string.format("\\u%04x", c:byte()) end)) .. '"'
+	elseif t == "table" and depth < 16 then
+		local n = 0
+		for _ in pairs(v) do
+			n = n + 1
+		end
+		local items = {}
+		if n > 0 and n == #v then
+			for i = 1, n do
+				items[i] = wcc_json(v[i], depth + 1)
+			end
+			return "[" .. table.concat(items, ",") .. "]"
+		end
+		for k, e in pairs(v) do
+			items[#items + 1] = wcc_json(tostring(k)) .. ":" .. wcc_json(e, depth + 1)
+		end
+		return "{" .. table.concat(items, ",") .. "}"
+	end
+	return wcc_json(tostring(v))
+end
+local function wcc_eval(code)
+	local f, err = load("return " .. code, "=eval")
+	if not f then
+		f, err = load(code, "=eval")
+	end
+	if not f then
+		print('{"ok":false,"error":' .. wcc_json(err) .. '}')
+		return
+	end
+	local r = table.pack(pcall(f))
+	if not r[1] then
+		print('{"ok":false,"error":' .. wcc_json(r[2]) .. '}')
+		return
+	end
+	local values = {}
+	for i = 2, r.n do
+		values[i - 1] = wcc_json(r[i])
+	end
+	print('{"ok":true,"values":[' .. table.concat(values, ",") .. ']}')
+end
+`
+
+// Result of a Lua code evaluation in JSON mode
+type EvalResult struct {
+	Ok     bool              `json:"ok"`
+	Values []json.RawMessage `json:"values"`
+	Error  string            `json:"error,omitempty"`
+	Output string            `json:"output"`
+}
+
+// Quote a string as a Lua string literal. Control chars are escaped
+// using 3 digits decimal escapes.
+func luaQuote(s string) string {
+	var quoted bytes.Buffer
+
+	quoted.WriteByte('"')
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if c == '"' || c == '\\' {
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		} else if c < 32 || c == 127 {
+			quoted.WriteString(fmt.Sprintf("\\%03d", c))
+		} else {
+			quoted.WriteByte(c)
+		}
+	}
+
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
+
+// Unquote a Lua string literal, quoted with luaQuote
+func luaUnquote(s string) (string, error) {
+	var unquoted bytes.Buffer
+
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", errors.New("invalid Lua string " + s)
+	}
+
+	s = s[1 : len(s)-1]
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			unquoted.WriteByte(s[i])
+			continue
+		}
+
+		if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+			unquoted.WriteByte(s[i+1])
+			i = i + 1
+		} else if i+3 < len(s) {
+			c, err := strconv.Atoi(s[i+1 : i+4])
+			if err != nil {
+				return "", errors.New("invalid Lua string " + s)
+			}
+
+			unquoted.WriteByte(byte(c))
+			i = i + 3
+		} else {
+			return "", errors.New("invalid Lua string " + s)
+		}
+	}
+
+	return unquoted.String(), nil
+}
+
+// Build a one line Lua command from a Lua snippet
+func luaOneLine(snippet string) string {
+	return strings.Join(strings.Fields(snippet), " ")
+}
+
+// Build a one line Lua command that runs Lua code as the shell does, as an
+// expression if possible, or as a statement if not, printing the values
+// returned by the code. Code is loaded from a string, so the line breaks,
+// and the comments, of multi-line code are kept.
+func luaLoadCode(code string) string {
+	return `local c = ` + luaQuote(code) + ` ` +
+		`local f = load("return " .. c, "=stdin") or assert(load(c, "=stdin")) ` +
+		`local r = table.pack(f()) ` +
+		`if r.n > 0 then print(table.unpack(r, 1, r.n)) end`
+}
+
+// Evaluate Lua code in the board, and get the board response. Returns an
+// error if the code raised a Lua error.
+func (board *Board) eval(code string) (string, error) {
+	return board.call(luaLoadCode(code))
+}
+
+// Evaluate Lua code in the board, getting the result as a JSON object
+func (board *Board) evalJSON(code string) (EvalResult, error) {
+	var result EvalResult
+
+	response, err := board.call(luaOneLine(luaEvalJSON) + " wcc_eval(" + luaQuote(code) + ")")
+	if err != nil {
+		return result, err
+	}
+
+	// Result is the last line, and previous lines are the output of the code
+	lines := strings.Split(response, "\r\n")
+
+	err = json.Unmarshal([]byte(lines[len(lines)-1]), &result)
+	if err != nil {
+		return result, errors.New("invalid response from board: " + response)
+	}
+
+	result.Output = strings.Join(lines[:len(lines)-1], "\n")
+
+	if result.Values == nil {
+		result.Values = []json.RawMessage{}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Whitecat Console, Lua code evaluation tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLuaQuote(t *testing.T) {
+	tests := []struct {
+		s      string
+		quoted string
+	}{
+		{"", `""`},
+		{"hello", `"hello"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`a\b`, `"a\\b"`},
+		{"a\nb\r", `"a\010b\013"`},
+		{"\x00\x1f\x7f", `"\000\031\127"`},
+		{"1\t2", `"1\0092"`},
+		{"año", `"año"`},
+	}
+
+	for _, test := range tests {
+		if quoted := luaQuote(test.s); quoted != test.quoted {
+			t.Errorf("luaQuote(%q) is %s, expected %s", test.s, quoted, test.quoted)
+		}
+	}
+}
+
+func TestLuaUnquote(t *testing.T) {
+	tests := []struct {
+		quoted string
+		s      string
+		ok     bool
+	}{
+		{`""`, "", true},
+		{`"hello"`, "hello", true},
+		{`"say \"hi\""`, `say "hi"`, true},
+		{`"a\\b"`, `a\b`, true},
+		{`"a\010b\013"`, "a\nb\r", true},
+		{`"\000\031\127"`, "\x00\x1f\x7f", true},
+		{`"1\0092"`, "1\t2", true},
+		{`hello`, "", false},
+		{`"hello`, "", false},
+		{`"`, "", false},
+		{`"a\"`, "", false},
+		{`"\01"`, "", false},
+		{`"\0x1"`, "", false},
+	}
+
+	for _, test := range tests {
+		s, err := luaUnquote(test.quoted)
+
+		if test.ok && err != nil {
+			t.Errorf("luaUnquote(%s) failed: %v", test.quoted, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("luaUnquote(%s) is %q, expected an error", test.quoted, s)
+		} else if s != test.s {
+			t.Errorf("luaUnquote(%s) is %q, expected %q", test.quoted, s, test.s)
+		}
+	}
+}
+
+func TestBoardEval(t *testing.T) {
+	tests := []struct {
+		code   string
+		output string
+		err    string
+	}{
+		{"os.version()", "Lua RTOS\tbeta 0.1\t" + simBuild + "\t" + simCommit, ""},
+		{"\"hello\"", "hello", ""},
+		{"print(\"hello\")", "hello", ""},
+		{"print(\"a\")\n-- comment\nprint(\"b\")", "a\r\nb", ""},
+		{"error(\"boom\")", "", "stdin:1: boom"},
+		{"print(\"a\")\nerror(\"boom\")", "a", "stdin:2: boom"},
+	}
+
+	board := openSimBoard(t)
+
+	for _, test := range tests {
+		output, err := board.eval(test.code)
+
+		if test.err == "" && err != nil {
+			t.Errorf("%q: failed: %v", test.code, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%q: error is %v, expected %q", test.code, err, test.err)
+		} else if output != test.output {
+			t.Errorf("%q: output is %q, expected %q", test.code, output, test.output)
+		}
+	}
+}
+
+func TestLuaQuoteUnquote(t *testing.T) {
+	var all []byte
+	for c := 0; c < 256; c++ {
+		all = append(all, byte(c))
+	}
+
+	s, err := luaUnquote(luaQuote(string(all)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s != string(all) {
+		t.Errorf("unquoted is %q, expected %q", s, all)
+	}
+}
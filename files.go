@@ -0,0 +1,462 @@
+/*
+ * Whitecat Console, board file system
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date format used by os.ls
+const lsDateLayout = "Jan 02 2006 15:04"
+
+// An entry of a directory in the board, as listed by os.ls. Entries is
+// only used in recursive listings, for the entries of a directory.
+type fileEntry struct {
+	Type    string      `json:"type"`
+	Size    int64       `json:"size"`
+	Date    string      `json:"date"`
+	Name    string      `json:"name"`
+	Entries []fileEntry `json:"entries,omitempty"`
+}
+
+// Entry is a directory?
+func (entry fileEntry) isDir() bool {
+	return entry.Type == "d"
+}
+
+// List a directory in the board. The os.ls output has a line per entry,
+// with the type, size, date and name columns separated by tabs.
+func (board *Board) list(dir string) ([]fileEntry, error) {
+	response, err := board.ls(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseListing(response), nil
+}
+
+// Parse the os.ls output. Lines without the 4 columns are not entries, for
+// example messages printed by the firmware, so they are logged and skipped.
+func parseListing(response string) []fileEntry {
+	entries := []fileEntry{}
+
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimRight(line, "\r")
+		columns := strings.Split(line, "\t")
+
+		if len(columns) != 4 {
+			if line != "" {
+				log.Println("unknown line in the os.ls output:", line)
+			}
+
+			continue
+		}
+
+		// Directories have no size
+		size, _ := strconv.ParseInt(columns[1], 10, 64)
+
+		entries = append(entries, fileEntry{Type: columns[0], Size: size, Date: columns[2], Name: columns[3]})
+	}
+
+	return entries
+}
+
+// List a directory in the board, and its subdirectories, as a tree
+func (board *Board) listTree(dir string) ([]fileEntry, error) {
+	entries, err := board.list(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries {
+		if entry.isDir() {
+			if entries[i].Entries, err = board.listTree(path.Join(dir, entry.Name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// Sort directory entries by name, size (biggest first) or date (newest
+// first), including the entries of the subdirectories
+func sortEntries(entries []fileEntry, by string) error {
+	var less func(a, b fileEntry) bool
+
+	switch by {
+	case "name":
+		less = func(a, b fileEntry) bool { return a.Name < b.Name }
+	case "size":
+		less = func(a, b fileEntry) bool { return a.Size > b.Size || (a.Size == b.Size && a.Name < b.Name) }
+	case "date":
+		less = func(a, b fileEntry) bool {
+			return a.modTime().After(b.modTime()) || (a.modTime().Equal(b.modTime()) && a.Name < b.Name)
+		}
+	default:
+		return errors.New("invalid sort " + by + ", use name, size or date")
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+
+	for _, entry := range entries {
+		if len(entry.Entries) > 0 {
+			sortEntries(entry.Entries, by)
+		}
+	}
+
+	return nil
+}
+
+// Format a size in bytes, with a K, M or G unit if human is true
+func formatSize(size int64, human bool) string {
+	if !human || size < 1024 {
+		return strconv.FormatInt(size, 10)
+	}
+
+	value := float64(size)
+	units := "KMG"
+
+	for i := 0; i < len(units); i++ {
+		value = value / 1024
+
+		if value < 1024 || i == len(units)-1 {
+			if value < 10 {
+				return strconv.FormatFloat(value, 'f', 1, 64) + string(units[i])
+			}
+
+			return strconv.FormatFloat(value, 'f', 0, 64) + string(units[i])
+		}
+	}
+
+	return strconv.FormatInt(size, 10)
+}
+
+// Get the absolute path of a file or directory in the board. Relative paths
+// are relative to the root directory.
+func boardPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// Create a directory in the board
+func (board *Board) mkdir(dir string) error {
+	_, err := board.call("assert(os.mkdir(" + luaQuote(boardPath(dir)) + "))")
+
+	return err
+}
+
+// Remove a file, or an empty directory, in the board
+func (board *Board) remove(name string) error {
+	_, err := board.call("assert(os.remove(" + luaQuote(boardPath(name)) + "))")
+
+	return err
+}
+
+// Remove a file, or a directory with all its content, in the board. The
+// root directory is never removed.
+func (board *Board) removeAll(name string) error {
+	if boardPath(name) == "/" {
+		return errors.New("can't remove the root directory")
+	}
+
+	entry, err := board.stat(name)
+	if err != nil {
+		return err
+	}
+
+	if entry.isDir() {
+		entries, err := board.list(name)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range entries {
+			if err := board.removeAll(path.Join(name, child.Name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return board.remove(name)
+}
+
+// Rename, or move, a file or directory in the board
+func (board *Board) rename(src string, dst string) error {
+	_, err := board.call("assert(os.rename(" + luaQuote(boardPath(src)) + ", " + luaQuote(boardPath(dst)) + "))")
+
+	return err
+}
+
+// Lua snippet that defines the wcc_copy function, that copies a file. The
+// snippet is sent in one line, so it can't contain comments.
+const luaCopy = `
+local function wcc_copy(src, dst)
+	local i = assert(io.open(src, "rb"))
+	local o, err = io.open(dst, "wb")
+	if not o then
+		i:close()
+		error(err, 0)
+	end
+	while true do
+		local s = i:read(256)
+		if not s then
+			break
+		end
+		o:write(s)
+	end
+	i:close()
+	o:close()
+end
+`
+
+// Milliseconds per KB allowed to the board code that reads a whole file,
+// as copy or checksum, because the board doesn't answer until the end
+const fileTimeoutPerKB = 100
+
+// Timeout, in milliseconds, for the board code that reads a whole file of
+// size bytes
+func fileTimeout(size int64) int {
+	return config.Timeouts.Transfer + int(size/1024)*fileTimeoutPerKB
+}
+
+// Copy a file in the board. The file is copied by the board, so it's not
+// transferred.
+func (board *Board) copy(src string, dst string) error {
+	entry, err := board.stat(src)
+	if err != nil {
+		return err
+	}
+
+	code := luaOneLine(luaCopy) + " wcc_copy(" + luaQuote(boardPath(src)) + ", " + luaQuote(boardPath(dst)) + ")"
+
+	_, err = board.callTimeout(code, fileTimeout(entry.Size))
+
+	return err
+}
+
+// Create an empty file in the board, if it doesn't exist
+func (board *Board) touch(name string) error {
+	_, err := board.call("assert(io.open(" + luaQuote(boardPath(name)) + ", \"a\")):close()")
+
+	return err
+}
+
+// Find the entry of a file or directory in the board, listing its parent
+// directory. Returns false if the parent directory doesn't have the entry.
+func (board *Board) find(name string) (fileEntry, bool, error) {
+	name = boardPath(name)
+
+	if name == "/" {
+		return fileEntry{Type: "d", Name: "/"}, true, nil
+	}
+
+	entries, err := board.list(path.Dir(name))
+	if err != nil {
+		return fileEntry{}, false, err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == path.Base(name) {
+			return entry, true, nil
+		}
+	}
+
+	return fileEntry{}, false, nil
+}
+
+// Get the entry of a file or directory in the board
+func (board *Board) stat(name string) (fileEntry, error) {
+	entry, found, err := board.find(name)
+	if err != nil {
+		return fileEntry{}, err
+	}
+
+	if !found {
+		return fileEntry{}, errors.New(boardPath(name) + ": No such file or directory")
+	}
+
+	return entry, nil
+}
+
+// Create the missing directories of a path in the board. Directories known
+// to exist are stored in known, so they are not listed again.
+func (board *Board) makeDirs(dir string, known map[string]bool) error {
+	dir = path.Clean("/" + dir)
+
+	if dir == "/" || known[dir] {
+		return nil
+	}
+
+	parent := path.Dir(dir)
+
+	if err := board.makeDirs(parent, known); err != nil {
+		return err
+	}
+
+	entries, err := board.list(parent)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == path.Base(dir) {
+			if !entry.isDir() {
+				return errors.New(dir + " exists, and is not a directory")
+			}
+
+			known[dir] = true
+
+			return nil
+		}
+	}
+
+	notify("progress", "\033[Kcreating "+dir+"\r\n")
+
+	if err := board.mkdir(dir); err != nil {
+		return err
+	}
+
+	known[dir] = true
+
+	return nil
+}
+
+// Upload a folder of the computer to a directory in the board, including
+// its subfolders. Returns the number of files and bytes sent.
+func (board *Board) putDir(src string, dst string) (int, int, error) {
+	var files, dirs []string
+	var total int64
+
+	err := filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			dirs = append(dirs, name)
+		} else {
+			files = append(files, name)
+			total = total + info.Size()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Create the directories first, so empty folders are created too
+	known := map[string]bool{}
+
+	for _, name := range dirs {
+		if err := board.makeDirs(remotePath(src, name, dst), known); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	sent := 0
+
+	for i, name := range files {
+		remote := remotePath(src, name, dst)
+
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return i, sent, err
+		}
+
+		notify("progress", "\033[Kfile "+strconv.Itoa(i+1)+" of "+strconv.Itoa(len(files))+", "+
+			strconv.Itoa(sent)+" of "+strconv.FormatInt(total, 10)+" bytes: "+name+" -> "+remote+"\r\n")
+
+		if err := board.writeFile(remote, content); err != nil {
+			return i, sent, err
+		}
+
+		sent = sent + len(content)
+	}
+
+	return len(files), sent, nil
+}
+
+// Get the path in the board for a file of a folder of the computer that is
+// uploaded to the dst directory
+func remotePath(folder string, name string, dst string) string {
+	rel, err := filepath.Rel(folder, name)
+	if err != nil {
+		rel = filepath.Base(name)
+	}
+
+	return path.Join(dst, filepath.ToSlash(rel))
+}
+
+// Walk a directory tree in the board, calling fn for each file and
+// directory found, with its path. Directories are walked after calling fn.
+func (board *Board) walk(dir string, fn func(name string, entry fileEntry) error) error {
+	entries, err := board.list(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name)
+
+		if err := fn(name, entry); err != nil {
+			return err
+		}
+
+		if entry.isDir() {
+			if err := board.walk(name, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get the modification time of an entry, or the zero time if the date
+// can't be parsed
+func (entry fileEntry) modTime() time.Time {
+	date, err := time.ParseInLocation(lsDateLayout, entry.Date, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return date
+}
@@ -30,15 +30,19 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/kardianos/osext"
+	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"os/user"
 	"path"
 	"runtime"
+	"strings"
+	"text/tabwriter"
 )
 
 var Version string = "2.2"
@@ -52,24 +56,69 @@ var AppFileName = ""
 var LastBuildURL = "http://whitecatboard.org/lastbuildv2.php"
 var FirmwareURL = "http://whitecatboard.org/firmwarev2.php"
 var SupportedBoardsURL = "https://raw.githubusercontent.com/whitecatboard/Lua-RTOS-ESP32/master/boards/boards.json"
+var EsptoolURL = "http://downloads.whitecatboard.org/esptool"
+
+// Global flags, that can be used with any command
+var (
+	flagPort       string
+	flagBaud       int
+	flagDebug      bool
+	flagReset      string
+	flagNoReset    bool
+	flagPulse      int
+	flagBoard      string
+	flagEvents     string
+	flagEventsFile string
+	flagELF        string
+)
+
+// Define the global flags in a flag set
+func globalFlags(fs *flag.FlagSet) {
+	portHelp := "serial port device, for example /dev/tty.SLAB_USBtoUART"
+	if runtime.GOOS == "windows" {
+		portHelp = "serial port, for example COM2"
+	}
+
+	portHelp = portHelp + ", tcp://host:port, telnet://host:port, rfc2217://host:port,\n" +
+		"sim:// for use the board simulator, or auto[:serial] for find the board"
+
+	fs.StringVar(&flagPort, "p", flagPort, portHelp)
+	fs.StringVar(&flagPort, "port", flagPort, "same as -p")
+	fs.IntVar(&flagBaud, "b", flagBaud, "baud rate, 115200 by default")
+	fs.IntVar(&flagBaud, "baud", flagBaud, "same as -b")
+	fs.BoolVar(&flagDebug, "d", flagDebug, "show debug messages")
+	fs.BoolVar(&flagDebug, "debug", flagDebug, "same as -d")
+	fs.StringVar(&flagReset, "reset", flagReset, "reset sequence used when attaching the board, one of "+strings.Join(resetSequenceNames(), ", ")+
+		", rts by default, none for attach without reset")
+	fs.BoolVar(&flagNoReset, "n", flagNoReset, "attach the board without reset it, same as -reset none")
+	fs.IntVar(&flagPulse, "pulse", flagPulse, "pulse time in ms in the reset sequence, 10 ms by default")
+	fs.StringVar(&flagBoard, "board", flagBoard, "use the baud rate and reset sequence of a board type, for example ESP32-THING, or defined in the configuration file")
+	fs.StringVar(&flagEvents, "events", flagEvents, "write all board events in a format, only json is supported")
+	fs.StringVar(&flagEventsFile, "events-file", flagEventsFile, "write the events to a file, instead of stderr")
+	fs.StringVar(&flagELF, "elf", flagELF, "ELF file of the board firmware, used for decode panic backtraces")
+}
 
 func usage() {
-	fmt.Println("usage: wcc -p port | -ports [-ls path | [-down source destination] | [-up source destination] | [-f | -ffs] | [-erase] | -d]\r\n")
-	fmt.Println("-ports:\t\t list all available serial ports on your computer")
+	fmt.Print("usage: wcc [global flags] command [flags] [arguments]\r\n\r\n")
+	fmt.Print("commands:\r\n\r\n")
 
-	if runtime.GOOS == "windows" {
-		fmt.Println("-p port:\t serial port, for example COM2")
-	} else {
-		fmt.Println("-p port:\t serial port device, for example /dev/tty.SLAB_USBtoUART")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	for _, cmd := range commands {
+		fmt.Fprintln(w, "  "+strings.TrimSpace(cmd.name+" "+cmd.args)+"\t"+cmd.description)
 	}
 
-	fmt.Println("-ls path:\t list files present in path")
-	fmt.Println("-down src dst:\t transfer the source file (board) to destination file (computer)")
-	fmt.Println("-up src dst:\t transfer the source file (computer) to destination file (board)")
-	fmt.Println("-f:\t\t flash board with last firmware")
-	fmt.Println("-ffs:\t\t flash board with last filesystem")
-	fmt.Println("-erase:\t\t erase flash board")
-	fmt.Println("-d:\t\t show debug messages\r\n")
+	w.Flush()
+
+	fmt.Print("\r\nglobal flags:\r\n\r\n")
+
+	global := flag.NewFlagSet("wcc", flag.ContinueOnError)
+	global.SetOutput(os.Stdout)
+	globalFlags(global)
+	global.PrintDefaults()
+
+	fmt.Print("\r\nUse wcc command --help for more information about a command.\r\n")
+	fmt.Print("The old command line, for example wcc -p port -ls path, is still supported.\r\n\r\n")
 }
 
 // posString returns the first index of element in slice.
@@ -87,132 +136,209 @@ func containsString(slice []string, element string) bool {
 	return !(posString(slice, element) == -1)
 }
 
-func main() {
-	defer func() {
-		if connectedBoard != nil {
-			connectedBoard.detach()
+// Commands of the old command line, and the number of arguments of each one
+var legacyCommands = map[string]int{
+	"-ls":      1,
+	"-down":    2,
+	"-up":      2,
+	"-f":       0,
+	"-ffs":     0,
+	"-erase":   0,
+	"-ports":   0,
+	"-t":       0,
+	"-run":     1,
+	"-e":       1,
+	"-json":    0,
+	"-timeout": 1,
+}
+
+// Flag is not a boolean flag, so it takes a value?
+func isValueFlag(f *flag.Flag) bool {
+	if b, ok := f.Value.(interface {
+		IsBoolFlag() bool
+	}); ok && b.IsBoolFlag() {
+		return false
+	}
+
+	return true
+}
+
+// Argument is a global flag that takes its value from the next argument?
+func isGlobalValueFlag(arg string) bool {
+	if !strings.HasPrefix(arg, "-") || strings.Contains(arg, "=") {
+		return false
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	globalFlags(fs)
+
+	f := fs.Lookup(strings.TrimLeft(arg, "-"))
+
+	return f != nil && isValueFlag(f)
+}
+
+// Move the flags that are after the arguments before them, so flags can be
+// used before or after the arguments. Arguments after -- are never taken as
+// flags.
+func reorderFlags(fs *flag.FlagSet, args []string) []string {
+	var flags, params []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			params = append(params, args[i+1:]...)
+			break
 		}
 
-		if err := recover(); err != nil {
-			fmt.Println("Error:", err)
+		if len(arg) < 2 || arg[0] != '-' {
+			params = append(params, arg)
+			continue
 		}
-	}()
 
-	port := ""
-	ports := false
-	dbg := false
-	ok := true
+		flags = append(flags, arg)
+
+		if strings.Contains(arg, "=") {
+			continue
+		}
+
+		if f := fs.Lookup(strings.TrimLeft(arg, "-")); f != nil && isValueFlag(f) && i+1 < len(args) {
+			flags = append(flags, args[i+1])
+			i = i + 1
+		}
+	}
+
+	return append(append(flags, "--"), params...)
+}
+
+// Translate the old command line, for example -p port -ls path, to the new
+// one, for example -p port ls path. If args is not an old command line,
+// args is returned unchanged.
+func legacyArgs(args []string) ([]string, error) {
+	var globals, flags, params []string
+
+	// Skip global flags, the first argument after them tells if this is an
+	// old command line
 	i := 0
-	ls := false
-	down := false
-	up := false
+	for i < len(args) && strings.HasPrefix(args[i], "-") {
+		if _, found := legacyCommands[args[i]]; found {
+			break
+		}
+
+		if isGlobalValueFlag(args[i]) {
+			i = i + 1
+		}
+
+		i = i + 1
+	}
+
+	if i >= len(args) {
+		return args, nil
+	}
+
+	if _, found := legacyCommands[args[i]]; !found {
+		return args, nil
+	}
+
+	command := ""
 	flash := false
 	flashFS := false
-	nextIsPort := false
-	nextIsSrc := false
-	nextIsDst := false
-	nextIsDir := false
-	erase := false
-	src := ""
-	dst := ""
-	dir := ""
-	response := ""
-
-	// Get arguments and process arguments
-	for _, arg := range os.Args {
-		if nextIsDir {
-			dir = arg
-			nextIsDir = false
-			continue
-		}
 
-		if nextIsSrc {
-			src = arg
-			nextIsSrc = false
-			nextIsDst = true
-			continue
+	setCommand := func(name string) error {
+		if command != "" && command != name {
+			return errors.New("only one command can be used")
 		}
 
-		if nextIsDst {
-			dst = arg
-			nextIsDst = false
+		command = name
+
+		return nil
+	}
+
+	for i = 0; i < len(args); i++ {
+		arg := args[i]
+
+		n, found := legacyCommands[arg]
+		if !found && arg != "--json" {
+			globals = append(globals, arg)
+
+			if isGlobalValueFlag(arg) && i+1 < len(args) {
+				globals = append(globals, args[i+1])
+				i = i + 1
+			}
+
 			continue
 		}
 
-		if nextIsPort {
-			port = arg
-			nextIsPort = false
-			continue
+		if i+n >= len(args) {
+			return nil, errors.New(arg + " needs " + fmt.Sprint(n) + " argument(s)")
 		}
 
-		switch arg {
-		case "-p":
-			port = arg
-			nextIsPort = true
+		var err error
 
+		switch arg {
 		case "-ls":
-			ls = true
-			nextIsDir = true
-
+			err = setCommand("ls")
 		case "-down":
-			down = true
-			nextIsSrc = true
-
+			err = setCommand("get")
 		case "-up":
-			up = true
-			nextIsSrc = true
-
-		case "-d":
-			dbg = true
-
+			err = setCommand("put")
 		case "-f":
+			err = setCommand("flash")
 			flash = true
-
 		case "-ffs":
+			err = setCommand("flash")
 			flashFS = true
-
-		case "-ports":
-			ports = true
-
 		case "-erase":
-			erase = true
+			err = setCommand("erase")
+		case "-ports":
+			err = setCommand("ports")
+		case "-t":
+			err = setCommand("terminal")
+		case "-run":
+			err = setCommand("run")
+		case "-e":
+			err = setCommand("eval")
+		case "-json", "--json":
+			flags = append(flags, "--json")
+		case "-timeout":
+			flags = append(flags, "--timeout", args[i+1])
+		}
 
-		default:
-			if i > 0 {
-				ok = false
-			}
+		if err != nil {
+			return nil, err
 		}
 
-		i = i + 1
-	}
+		if arg != "-timeout" {
+			params = append(params, args[i+1:i+1+n]...)
+		}
 
-	if ports {
-		fmt.Println("Available serial ports on your computer:\r\n")
-		list_ports()
-		os.Exit(1)
+		i = i + n
 	}
 
-	if (!erase && !up && !down && !ls && !(flash || flashFS)) || (port == "") {
-		ok = false
+	if flashFS && flash {
+		flags = append(flags, "--fs")
+	} else if flashFS {
+		flags = append(flags, "--fs-only")
 	}
 
-	if erase && (flash || flashFS) {
-		ok = false
+	// The old listing format is kept, unless a JSON listing is requested
+	if command == "ls" && !containsString(flags, "--json") {
+		flags = append(flags, "--raw")
 	}
 
-	if !ok {
-		usage()
-		os.Exit(1)
+	if command == "" {
+		return nil, errors.New("no command")
 	}
 
-	if !dbg {
-		log.SetOutput(ioutil.Discard)
-	}
+	return append(append(append(append(globals, command), flags...), "--"), params...), nil
+}
 
-	// Get home directory, create the user data folder, and needed folders
+// Get the user data folder
+func getAppDataFolder() error {
+	// Get home directory
 	usr, err := user.Current()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	if runtime.GOOS == "darwin" {
@@ -223,6 +349,11 @@ func main() {
 		AppDataFolder = path.Join(usr.HomeDir, ".whitecat-create-agent")
 	}
 
+	return nil
+}
+
+// Create the user data folder, and needed folders
+func setupFolders() error {
 	AppDataTmpFolder = path.Join(AppDataFolder, "tmp")
 
 	// Clean tmp folder
@@ -234,7 +365,7 @@ func main() {
 	// Get where program is executed
 	execFolder, err := osext.ExecutableFolder()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	AppFolder = execFolder
@@ -245,61 +376,106 @@ func main() {
 	log.Println("AppDataFolder: ", AppDataFolder)
 	log.Println("AppDataTmpFolder: ", AppDataTmpFolder)
 
-	if ls {
-		if dir == "" {
-			usage()
-			os.Exit(1)
-		}
-	} else if down {
-		if src == "" || dst == "" {
-			usage()
-			os.Exit(1)
+	return nil
+}
+
+// Names of the flags given in the command line
+func givenFlags(sets ...*flag.FlagSet) map[string]bool {
+	given := map[string]bool{}
+
+	for _, fs := range sets {
+		fs.Visit(func(f *flag.Flag) {
+			given[f.Name] = true
+		})
+	}
+
+	return given
+}
+
+// Build the attach configuration. Board type configuration can be
+// overwritten by the global flags given in the command line, and by the
+// baud rate of the configuration file.
+func buildAttachConfig(given map[string]bool) error {
+	if flagBoard != "" {
+		config, err := boardAttachConfig(flagBoard)
+		if err != nil {
+			return err
 		}
-	} else if up {
-		if src == "" || dst == "" {
-			usage()
-			os.Exit(1)
+
+		attachConfig = config
+	}
+
+	if given["b"] || given["baud"] || config.Baud != 0 {
+		attachConfig.BaudRate = flagBaud
+	}
+
+	if given["reset"] {
+		attachConfig.Reset = flagReset
+	}
+
+	if flagNoReset {
+		attachConfig.Reset = "none"
+	}
+
+	if given["pulse"] {
+		attachConfig.Pulse = flagPulse
+	}
+
+	return attachConfig.check()
+}
+
+// Connect to the board, and test that it has a valid firmware. If not,
+// user can install a valid firmware.
+func connectBoard(cmd *command) error {
+	var err error
+
+	port := config.resolvePort(flagPort)
+
+	// Find the board port, if requested
+	if isAutoPort(port) {
+		port, err = findBoardPort(port)
+		if err != nil {
+			return err
 		}
 	}
 
 	// Connect board
-	connect(port)
-	if connectedBoard == nil {
-		fmt.Println("Can't connect to any board at port " + port + ".\r\n")
-		fmt.Println("Available serial ports on your computer:\r\n")
-		list_ports()
-		os.Exit(1)
+	if cmd.passive {
+		err = connectPassive(port)
+	} else {
+		err = connect(port)
 	}
 
-	if connectedBoard.validFirmware {
-		connectedBoard.consoleOut = false
-		connectedBoard.consoleIn = true
-		connectedBoard.timeout(2000)
-		connectedBoard.model = connectedBoard.sendCommand("do local type = os.board();print(type); end")
-		connectedBoard.subtype = connectedBoard.sendCommand("do local _,subtype = os.board();if (not (subtype == nil)) then print(subtype); else print(\"\"); end end")
-		connectedBoard.brand = connectedBoard.sendCommand("do local _,_,brand = os.board();if (not (brand == nil)) then print(brand); else print(\"\"); end end")
-		connectedBoard.noTimeout()
-		connectedBoard.consoleOut = true
-		connectedBoard.consoleIn = false
-
-		firmware := ""
+	if err != nil {
+		if exitCode(err) == exitNoPort {
+			fmt.Print("Can't connect to any board at port " + port + ".\r\n\r\n")
+			fmt.Print("Available serial ports on your computer:\r\n\r\n")
+			if err := list_ports(false); err != nil {
+				log.Println("can't list ports:", err)
+			}
 
-		if connectedBoard.brand != "" {
-			firmware = connectedBoard.brand + "-"
+			fmt.Print("\r\n")
 		}
 
-		firmware = firmware + connectedBoard.model
+		return err
+	}
+
+	if cmd.passive {
+		return nil
+	}
 
-		if connectedBoard.subtype != "" {
-			firmware = firmware + "-" + connectedBoard.subtype
+	if connectedBoard.validFirmware {
+		if err = connectedBoard.getBoardInfo(); err != nil {
+			return err
 		}
 
-		connectedBoard.firmware = firmware
+		// Load the symbols for decode the panics, while the command runs
+		preloadSymbolizer(connectedBoard.firmware)
 	} else {
 		connectedBoard.noTimeout()
 	}
 
-	if (connectedBoard.model == "") && !erase {
+	if (connectedBoard.model == "") && !cmd.anyFirmware {
 		conf := ""
 		okayResponses := []string{"y", "Y", "yes", "Yes", "YES"}
 		nokayResponses := []string{"n", "N", "no", "No", "NO"}
@@ -311,91 +487,157 @@ func main() {
 			fmt.Print("\nDo you want to install a valid firmware now [y/n])? ")
 
 			_, err := fmt.Scanln(&conf)
-			if err == nil {
-				if containsString(okayResponses, conf) {
-					for {
-						fmt.Print("\r\n")
-						connectedBoard.selectSupportedBoard()
-						connectedBoard.upgrade(false, true, flashFS)
-						notify("progress", "board upgraded\r\n")
-
-						os.Exit(1)
-					}
-					os.Exit(1)
-				} else if containsString(nokayResponses, conf) {
-					os.Exit(1)
+			if err == io.EOF || (err == nil && containsString(nokayResponses, conf)) {
+				return classify(exitBadFirmware, "", errors.New("board hasn't a valid firmware"))
+			} else if err == nil && containsString(okayResponses, conf) {
+				fmt.Print("\r\n")
+
+				if err = connectedBoard.selectSupportedBoard(); err != nil {
+					return err
 				}
+
+				if err = connectedBoard.upgrade(false, true, false); err != nil {
+					return err
+				}
+
+				notify("progress", "board upgraded, run the command again\r\n")
+
+				exit(exitOk)
 			}
+		}
+	}
 
+	return nil
+}
+
+func main() {
+	// Load the user configuration, that sets the defaults of the global
+	// flags
+	err := getAppDataFolder()
+	if err == nil {
+		err = loadConfig()
+	}
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(exitFailure)
+	}
+
+	args, err := legacyArgs(os.Args[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		usage()
+		os.Exit(exitFailure)
+	}
+
+	// Parse the global flags, and get the command
+	global := flag.NewFlagSet("wcc", flag.ContinueOnError)
+	global.SetOutput(os.Stdout)
+	global.Usage = usage
+	globalFlags(global)
+
+	if err = global.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitOk)
 		}
+
+		os.Exit(exitFailure)
 	}
 
-	if ls {
-		connectedBoard.consoleOut = false
-		connectedBoard.consoleIn = true
-		connectedBoard.timeout(2000)
-		response = connectedBoard.sendCommand("os.ls(\"" + dir + "\")")
-		connectedBoard.noTimeout()
-		connectedBoard.consoleOut = true
-		connectedBoard.consoleIn = false
-		fmt.Println(response)
-	} else if down {
-		file := connectedBoard.readFile(src)
-		err := ioutil.WriteFile(dst, file, 0755)
-		if err != nil {
-			panic(err)
+	if global.NArg() == 0 {
+		usage()
+		os.Exit(exitFailure)
+	}
+
+	cmd := findCommand(global.Arg(0))
+	if cmd == nil {
+		fmt.Println("Error: unknown command " + global.Arg(0))
+		usage()
+		os.Exit(exitFailure)
+	}
+
+	// Parse the command flags, and run the command
+	fs := flag.NewFlagSet("wcc "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = cmd.usage
+	globalFlags(fs)
+	run := cmd.setup(fs)
+
+	if err = fs.Parse(reorderFlags(fs, global.Args()[1:])); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitOk)
 		}
-	} else if up {
-		file, err := ioutil.ReadFile(src)
-		if err != nil {
-			panic(err)
+
+		os.Exit(exitFailure)
+	}
+
+	if fs.NArg() < cmd.minArgs || (cmd.maxArgs >= 0 && fs.NArg() > cmd.maxArgs) {
+		cmd.usage()
+		os.Exit(exitFailure)
+	}
+
+	if err = buildAttachConfig(givenFlags(global, fs)); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(exitFailure)
+	}
+
+	if !flagDebug {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	if flagEvents != "" {
+		if err = startEvents(flagEvents, flagEventsFile); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitFailure)
 		}
+	}
 
-		connectedBoard.writeFile(dst, file)
-	} else if flash || flashFS {
-		newBuild := false
+	if cmd.board {
+		if flagPort == "" {
+			fmt.Println("Error: no port, use -p port")
+			os.Exit(exitFailure)
+		}
 
-		connectedBoard.consoleOut = false
-		connectedBoard.consoleIn = true
-		connectedBoard.timeout(2000)
-		commit := connectedBoard.sendCommand("do local commit; _, _, _, commit = os.version();print(commit);end")
-		connectedBoard.noTimeout()
-		connectedBoard.consoleOut = true
-		connectedBoard.consoleIn = false
-		lastCommit := ""
-
-		// Test for a new firmware version
-		resp, err := http.Get(LastBuildURL + "?firmware=" + connectedBoard.firmware)
-		if err == nil {
-			body, err := ioutil.ReadAll(resp.Body)
-			if err == nil {
-				lastCommit = string(body)
-
-				log.Println("current commit ", commit)
-				log.Println("last commit ", lastCommit)
-
-				if (commit != lastCommit) && (lastCommit != "") {
-					newBuild = true
-					notify("progress", "new firmware available "+commit+"\r\n")
-				} else {
-					notify("progress", "board is updated "+commit+"\r\n")
-				}
-			} else {
-				panic(err)
-			}
-		} else {
-			panic(err)
+		if err = setupFolders(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitFailure)
 		}
 
-		if newBuild || flashFS {
-			connectedBoard.upgrade(false, newBuild && flash, flashFS)
-			notify("progress", "board upgraded to "+lastCommit+"\r\n")
+		if err = connectBoard(cmd); err != nil {
+			fail(err)
 		}
-	} else if erase {
-		connectedBoard.upgrade(true, false, false)
-		notify("progress", "Board erased           \r\n")
 	}
 
-	// Clean tmp folder
-	os.RemoveAll(AppDataTmpFolder + "/")
+	if err = run(fs.Args()); err != nil {
+		fail(err)
+	}
+
+	exit(exitOk)
+}
+
+// Detach the board, close the event stream, clean the tmp folder, and exit
+// with an exit code
+func exit(code int) {
+	if board := currentBoard(); board != nil {
+		board.detach()
+	}
+
+	stopEvents()
+
+	// Only clean the tmp folder if it was created
+	if AppDataTmpFolder != "/tmp" {
+		os.RemoveAll(AppDataTmpFolder + "/")
+	}
+
+	os.Exit(code)
+}
+
+// Show an error, unless it was already reported, and exit with the exit
+// code of the error's failure class
+func fail(err error) {
+	if !isReported(err) {
+		fmt.Println("Error:", err)
+	}
+
+	exit(exitCode(err))
 }
@@ -0,0 +1,121 @@
+/*
+ * Whitecat Console, command line tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestLegacyArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+		ok       bool
+	}{
+		{[]string{}, []string{}, true},
+		{[]string{"-p", "/dev/ttyUSB0", "ls", "/"}, []string{"-p", "/dev/ttyUSB0", "ls", "/"}, true},
+		{[]string{"-p", "/dev/ttyUSB0", "-ls", "/"}, []string{"-p", "/dev/ttyUSB0", "ls", "--raw", "--", "/"}, true},
+		{[]string{"-p=/dev/ttyUSB0", "-run", "main.lua"}, []string{"-p=/dev/ttyUSB0", "run", "--", "main.lua"}, true},
+		{[]string{"-p", "x", "-down", "a.lua", "b.lua"}, []string{"-p", "x", "get", "--", "a.lua", "b.lua"}, true},
+		{[]string{"-p", "x", "-up", "a.lua", "b.lua"}, []string{"-p", "x", "put", "--", "a.lua", "b.lua"}, true},
+		{[]string{"-d", "-ls", "/"}, []string{"-d", "ls", "--raw", "--", "/"}, true},
+		{[]string{"-elf", "lua_rtos.elf", "-t"}, []string{"-elf", "lua_rtos.elf", "terminal", "--"}, true},
+		{[]string{"-board", "thing", "-ports"}, []string{"-board", "thing", "ports", "--"}, true},
+		{[]string{"-board", "-ls", "x"}, []string{"-board", "-ls", "x"}, true},
+		{[]string{"-f"}, []string{"flash", "--"}, true},
+		{[]string{"-ffs"}, []string{"flash", "--fs-only", "--"}, true},
+		{[]string{"-f", "-ffs"}, []string{"flash", "--fs", "--"}, true},
+		{[]string{"-erase", "-p", "x"}, []string{"-p", "x", "erase", "--"}, true},
+		{[]string{"-e", "print(1)", "-json", "-timeout", "5"}, []string{"eval", "--json", "--timeout", "5", "--", "print(1)"}, true},
+		{[]string{"-ls"}, nil, false},
+		{[]string{"-down", "a.lua"}, nil, false},
+		{[]string{"-ls", "/", "-t"}, nil, false},
+		{[]string{"-json", "-ls", "/"}, []string{"ls", "--json", "--", "/"}, true},
+		{[]string{"-ls", "/", "-json"}, []string{"ls", "--json", "--", "/"}, true},
+	}
+
+	for _, test := range tests {
+		args, err := legacyArgs(test.args)
+
+		if test.ok && err != nil {
+			t.Errorf("legacyArgs(%q) failed: %v", test.args, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("legacyArgs(%q) is %q, expected an error", test.args, args)
+		} else if test.expected != nil && !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("legacyArgs(%q) is %q, expected %q", test.args, args, test.expected)
+		}
+	}
+}
+
+func TestBuildAttachConfig(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected AttachConfig
+		ok       bool
+	}{
+		{[]string{}, AttachConfig{BaudRate: 115200, Reset: "rts", Pulse: 10}, true},
+		{[]string{"-board", "ESP32-THING"}, AttachConfig{BaudRate: 115200, Reset: "esptool", Pulse: 100}, true},
+		{[]string{"-board", "ESP32-THING", "-pulse", "0"}, AttachConfig{BaudRate: 115200, Reset: "esptool", Pulse: 0}, true},
+		{[]string{"-board", "ESP32-THING", "-reset", "dtr", "-b", "921600"}, AttachConfig{BaudRate: 921600, Reset: "dtr", Pulse: 100}, true},
+		{[]string{"-board", "ESP32-THING", "-n"}, AttachConfig{BaudRate: 115200, Reset: "none", Pulse: 100}, true},
+		{[]string{"-b", "0"}, AttachConfig{}, false},
+		{[]string{"-reset", ""}, AttachConfig{}, false},
+	}
+
+	saved := attachConfig
+	defer func() { attachConfig = saved }()
+
+	for _, test := range tests {
+		attachConfig = defaultAttachConfig
+
+		flagBoard, flagBaud, flagReset, flagNoReset, flagPulse = "", 0, "", false, 0
+
+		fs := flag.NewFlagSet("wcc", flag.ContinueOnError)
+		globalFlags(fs)
+
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+
+		err := buildAttachConfig(givenFlags(fs))
+		if test.ok != (err == nil) {
+			t.Errorf("%v: error is %v", test.args, err)
+			continue
+		}
+
+		if test.ok && attachConfig != test.expected {
+			t.Errorf("%v: configuration is %+v, expected %+v", test.args, attachConfig, test.expected)
+		}
+	}
+
+	flagBoard, flagBaud, flagReset, flagNoReset, flagPulse = "", 0, "", false, 0
+}
@@ -0,0 +1,278 @@
+/*
+ * Whitecat Console, serial monitor
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of the timestamps in monitor mode
+const monitorTimeLayout = "2006-01-02 15:04:05.000"
+
+// A list of regular expressions, that can be set many times in the
+// command line
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) String() string {
+	exprs := []string{}
+
+	for _, re := range *l {
+		exprs = append(exprs, re.String())
+	}
+
+	return strings.Join(exprs, ", ")
+}
+
+func (l *regexpList) Set(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+
+	*l = append(*l, re)
+
+	return nil
+}
+
+// Test if any regular expression in the list matches s
+func (l regexpList) match(s string) bool {
+	for _, re := range l {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A log file that is rotated when it reaches a maximum size. Old files are
+// renamed to name.1, name.2, ..., and only count old files are kept.
+type rotatingLog struct {
+	name    string
+	maxSize int64
+	count   int
+	file    *os.File
+	size    int64
+}
+
+func openRotatingLog(name string, maxSize int64, count int) (*rotatingLog, error) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &rotatingLog{name: name, maxSize: maxSize, count: count, file: file, size: info.Size()}, nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := l.file.Write(p)
+	l.size = l.size + int64(n)
+
+	return n, err
+}
+
+// Close the current log file
+func (l *rotatingLog) Close() error {
+	return l.file.Close()
+}
+
+func (l *rotatingLog) rotate() error {
+	l.file.Close()
+
+	for i := l.count - 1; i > 0; i-- {
+		os.Rename(l.name+"."+strconv.Itoa(i), l.name+"."+strconv.Itoa(i+1))
+	}
+
+	if l.count > 0 {
+		os.Rename(l.name, l.name+"."+strconv.Itoa(1))
+	}
+
+	file, err := os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.file = file
+	l.size = 0
+
+	return nil
+}
+
+// Current line in monitor output
+var monitorLine []byte
+
+var monitorHighlight highlighter
+
+// Monitor options
+var (
+	monitorTimestamps bool
+	monitorInclude    regexpList
+	monitorExclude    regexpList
+	monitorLog        io.Writer
+)
+
+var monitorMutex sync.Mutex
+
+// Write a char received from the board in the monitor output
+func monitorOutput(c byte) {
+	if c == '\n' {
+		line := string(monitorLine)
+
+		monitorLine = monitorLine[:0]
+
+		color := monitorHighlight.color(line)
+
+		if len(monitorInclude) > 0 && !monitorInclude.match(line) {
+			return
+		}
+
+		if monitorExclude.match(line) {
+			return
+		}
+
+		monitorWrite(line, color)
+	} else if c != '\r' {
+		monitorLine = append(monitorLine, c)
+	}
+}
+
+// Write a line in the monitor output, and in the log file
+func monitorWrite(line string, color string) {
+	monitorMutex.Lock()
+	defer monitorMutex.Unlock()
+
+	if monitorTimestamps {
+		line = time.Now().Format(monitorTimeLayout) + " " + line
+	}
+
+	if color != "" {
+		os.Stdout.Write([]byte(color + line + terminalColorReset + "\r\n"))
+	} else {
+		os.Stdout.Write([]byte(line + "\r\n"))
+	}
+
+	if monitorLog != nil {
+		if _, err := monitorLog.Write([]byte(line + "\n")); err != nil {
+			log.Println("can't write log:", err)
+		}
+	}
+}
+
+// Write a monitor status message
+func monitorStatus(message string) {
+	monitorWrite("--- "+message, terminalColorWarning)
+}
+
+func setupMonitor(fs *flag.FlagSet) func(args []string) error {
+	fs.BoolVar(&monitorTimestamps, "timestamps", false, "show the time at the beginning of each line")
+	fs.Var(&monitorInclude, "include", "show only lines that match a regular expression, can be used many times")
+	fs.Var(&monitorExclude, "exclude", "don't show lines that match a regular expression, can be used many times")
+	logFile := fs.String("log", "", "write the output to a log file")
+	logSize := fs.Int("log-size", 10, "rotate the log file when it reaches a size in MB, 0 for never rotate")
+	logFiles := fs.Int("log-files", 5, "number of rotated log files that are kept")
+
+	return func(args []string) error {
+		if *logSize < 0 {
+			return fmt.Errorf("invalid log size %d", *logSize)
+		}
+
+		if *logFiles < 1 {
+			return fmt.Errorf("invalid number of log files %d", *logFiles)
+		}
+
+		if *logFile != "" {
+			l, err := openRotatingLog(*logFile, int64(*logSize)*1024*1024, *logFiles)
+			if err != nil {
+				return err
+			}
+
+			monitorLog = l
+
+			// Close the current log file, that changes when it's rotated
+			defer func() {
+				monitorMutex.Lock()
+				defer monitorMutex.Unlock()
+
+				monitorLog = nil
+				l.Close()
+			}()
+		}
+
+		return monitor()
+	}
+}
+
+// Print the board output until Ctrl-C is pressed. If the board is
+// disconnected, the monitor waits for it, and reopens the port.
+func monitor() error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	defer signal.Stop(interrupt)
+
+	connectedBoard.consoleOut = true
+	connectedBoard.consoleIn = false
+
+	setConsoleMode(consoleModeMonitor)
+
+	defer setConsoleMode(consoleModeNone)
+
+	monitorStatus("monitoring " + connectedBoard.dev + ", press Ctrl-C to exit")
+
+	stop := make(chan struct{})
+	go keepConnected(stop, monitorStatus)
+
+	<-interrupt
+	close(stop)
+
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Whitecat Console, network transport
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Transport for boards reachable through a TCP connection, such as a Lua
+// RTOS board running the telnet server. There are not control lines, so
+// the board can't be reset using this transport.
+type netTransport struct {
+	conn net.Conn
+
+	// If not nil, data is decoded / encoded using the telnet protocol
+	telnet *telnet
+
+	// Protects writes, that can be done from the inspector when telnet
+	// negotiation replies are sent
+	wmu sync.Mutex
+
+	raw []byte
+}
+
+func openNetTransport(address string, useTelnet bool) (*netTransport, error) {
+	conn, err := net.DialTimeout("tcp", address, time.Second*5)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &netTransport{conn: conn}
+
+	if useTelnet {
+		t.telnet = newTelnet(t.writeRaw)
+
+		// We want a clean 8-bit channel, needed for file transfers
+		t.telnet.do(telnetOptSGA)
+		t.telnet.will(telnetOptSGA)
+		t.telnet.do(telnetOptBinary)
+		t.telnet.will(telnetOptBinary)
+	}
+
+	return t, nil
+}
+
+func (t *netTransport) writeRaw(b []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	_, err := t.conn.Write(b)
+
+	return err
+}
+
+func (t *netTransport) Read(b []byte) (int, error) {
+	if t.telnet == nil {
+		return t.conn.Read(b)
+	}
+
+	if len(t.raw) < len(b) {
+		t.raw = make([]byte, len(b))
+	}
+
+	// Read until some data remains after removing telnet commands
+	for {
+		n, err := t.conn.Read(t.raw[:len(b)])
+		if err != nil {
+			return 0, err
+		}
+
+		if n = t.telnet.decode(b, t.raw[:n]); n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (t *netTransport) Write(b []byte) (int, error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	if t.telnet == nil {
+		return t.conn.Write(b)
+	}
+
+	if _, err := t.conn.Write(t.telnet.encode(b)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (t *netTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *netTransport) SetRTS(on bool) error {
+	return errNoControlLines
+}
+
+func (t *netTransport) SetDTR(on bool) error {
+	return errNoControlLines
+}
+
+func (t *netTransport) HasControlLines() bool {
+	return false
+}
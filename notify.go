@@ -30,10 +30,125 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Notification fields that are base64 encoded
+var encodedFields = []string{"message", "block", "error"}
+
+// A notification, as written in the event stream
+type event struct {
+	Time time.Time              `json:"time"`
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// Stream where notifications are written as JSON lines, or nil if the event
+// stream is not enabled
+var eventStream io.Writer
+
+var eventMutex sync.Mutex
+
+// File opened for the event stream, or nil if events go to stderr
+var eventFile *os.File
+
+// Enable the event stream in format. Events are written to file, or to
+// stderr if file is empty.
+func startEvents(format string, file string) error {
+	if format != "json" {
+		return errors.New("invalid events format " + format + ", only json is supported")
+	}
+
+	if file == "" {
+		eventStream = os.Stderr
+
+		return nil
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	eventStream = f
+	eventFile = f
+
+	return nil
+}
+
+// Stop the event stream, and close its file if one was opened
+func stopEvents() {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+
+	eventStream = nil
+
+	if eventFile != nil {
+		eventFile.Close()
+		eventFile = nil
+	}
+}
+
+// Decode the data of a notification. Data can be a list of JSON fields,
+// with some fields base64 encoded, or a text.
+func decodeEventData(data string) map[string]interface{} {
+	var fields map[string]interface{}
+
+	if err := json.Unmarshal([]byte("{"+data+"}"), &fields); err == nil {
+		for _, name := range encodedFields {
+			if value, ok := fields[name].(string); ok {
+				if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+					fields[name] = string(decoded)
+				}
+			}
+		}
+
+		return fields
+	}
+
+	// Remove the terminal control sequences used for show the progress
+	text := strings.TrimSpace(strings.Replace(data, "\033[K", "", -1))
+	if text == "" {
+		return nil
+	}
+
+	return map[string]interface{}{"text": text}
+}
+
+// Write a notification to the event stream
+func writeEvent(notification string, data string) {
+	out, err := json.Marshal(event{
+		Time: time.Now(),
+		Type: notification,
+		Data: decodeEventData(data),
+	})
+	if err != nil {
+		return
+	}
+
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+
+	if eventStream == nil {
+		return
+	}
+
+	eventStream.Write(append(out, '\n'))
+}
+
+// If true, progress notifications are not shown, used by commands that
+// write data to stdout
+var quietProgress bool
+
 /*
  * This is an empty definition for the notify function used in
  * The Whitecat Create Agent, and needed for minimize changes
@@ -41,8 +156,14 @@ import (
  */
 
 func notify(notification string, data string) {
+	if eventStream != nil {
+		writeEvent(notification, data)
+	}
+
 	if (notification == "progress") {
-		fmt.Print(data)
+		if !quietProgress {
+			fmt.Print(data)
+		}
 	} else if(notification == "boardUpdate") {
 		if runtime.GOOS == "windows" {
 			fmt.Print("                                                                                                                        \r" + data + "\r")
@@ -0,0 +1,180 @@
+/*
+ * Whitecat Console, ESP32 panic decoding
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// A frame of a panic backtrace
+type backtraceFrame struct {
+	PC       string `json:"pc"`
+	SP       string `json:"sp"`
+	Function string `json:"function,omitempty"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+// A firmware panic, such as a Guru Meditation Error or an abort
+type boardPanic struct {
+	Reason    string            `json:"reason"`
+	Core      string            `json:"core"`
+	Registers map[string]string `json:"registers,omitempty"`
+	Backtrace []backtraceFrame  `json:"backtrace"`
+}
+
+var (
+	panicGuruRe      = regexp.MustCompile(`^Guru Meditation Error: Core\s+(\d+) panic'ed \(([^)]*)\)`)
+	panicGuruOldRe   = regexp.MustCompile(`^Guru Meditation Error of type (\S+) occurred on core\s+(\d+)`)
+	panicAbortRe     = regexp.MustCompile(`^abort\(\) was called at PC (0x[0-9a-fA-F]+) on core (\d+)`)
+	panicRegisterRe  = regexp.MustCompile(`([A-Z][A-Z0-9]*)\s*:\s*(0x[0-9a-fA-F]+)`)
+	panicBacktraceRe = regexp.MustCompile(`^Backtrace:(.*)$`)
+	panicFrameRe     = regexp.MustCompile(`(0x[0-9a-fA-F]+):(0x[0-9a-fA-F]+)`)
+	panicEndRe       = regexp.MustCompile(`^(Rebooting\.\.\.|rst:.*)$`)
+)
+
+// Parses the lines received from the board, looking for firmware panics
+type panicParser struct {
+	current *boardPanic
+}
+
+// Parse a line received from the board. Returns the panic when it's
+// completed, that is when the backtrace is found, or nil.
+func (p *panicParser) parse(line string) *boardPanic {
+	if parts := panicGuruRe.FindStringSubmatch(line); parts != nil {
+		return p.start(&boardPanic{Reason: parts[2], Core: parts[1]})
+	}
+
+	if parts := panicGuruOldRe.FindStringSubmatch(line); parts != nil {
+		return p.start(&boardPanic{Reason: parts[1], Core: parts[2]})
+	}
+
+	if parts := panicAbortRe.FindStringSubmatch(line); parts != nil {
+		return p.start(&boardPanic{Reason: "abort() was called at PC " + parts[1], Core: parts[2]})
+	}
+
+	if p.current == nil {
+		return nil
+	}
+
+	if parts := panicBacktraceRe.FindStringSubmatch(line); parts != nil {
+		for _, frame := range panicFrameRe.FindAllStringSubmatch(parts[1], -1) {
+			p.current.Backtrace = append(p.current.Backtrace, backtraceFrame{PC: frame[1], SP: frame[2]})
+		}
+
+		return p.end()
+	}
+
+	if panicEndRe.MatchString(line) {
+		return p.end()
+	}
+
+	for _, register := range panicRegisterRe.FindAllStringSubmatch(line, -1) {
+		p.current.Registers[register[1]] = register[2]
+	}
+
+	return nil
+}
+
+// Start a new panic. If there is a panic in progress, it's returned.
+func (p *panicParser) start(bp *boardPanic) *boardPanic {
+	previous := p.end()
+
+	bp.Registers = map[string]string{}
+	bp.Backtrace = []backtraceFrame{}
+	p.current = bp
+
+	return previous
+}
+
+// End the panic in progress, and return it
+func (p *panicParser) end() *boardPanic {
+	bp := p.current
+	p.current = nil
+
+	return bp
+}
+
+// Decode the backtrace addresses to function, file and line, using the
+// firmware's ELF file
+func (bp *boardPanic) decode(s *symbolizer) {
+	if s == nil {
+		return
+	}
+
+	for i, frame := range bp.Backtrace {
+		address, err := strconv.ParseUint(frame.PC, 0, 64)
+		if err != nil {
+			continue
+		}
+
+		bp.Backtrace[i].Function, bp.Backtrace[i].File, bp.Backtrace[i].Line = s.lookup(address)
+	}
+}
+
+// Get the notification info for the panic
+func (bp *boardPanic) info() string {
+	out, err := json.Marshal(bp)
+	if err != nil {
+		return ""
+	}
+
+	// Remove the braces, notification info is a list of fields
+	return string(out[1 : len(out)-1])
+}
+
+// Get the decoded backtrace as text, one line per frame, or an empty string
+// if the backtrace is not decoded
+func (bp *boardPanic) decodedBacktrace() string {
+	text := ""
+
+	for _, frame := range bp.Backtrace {
+		if frame.Function == "" && frame.File == "" {
+			continue
+		}
+
+		function := frame.Function
+		if function == "" {
+			function = "??"
+		}
+
+		location := "??:?"
+		if frame.File != "" {
+			location = fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+
+		text = text + frame.PC + ": " + function + " at " + location + "\r\n"
+	}
+
+	return text
+}
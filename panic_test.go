@@ -0,0 +1,165 @@
+/*
+ * Whitecat Console, ESP32 panic decoding tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPanicParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		panics []boardPanic
+	}{
+		{
+			"guru meditation",
+			[]string{
+				"Guru Meditation Error: Core  0 panic'ed (LoadProhibited). Exception was unhandled.",
+				"Core 0 register dump:",
+				"PC      : 0x400d0b4c  PS      : 0x00060030  A0      : 0x800d0b4c  A1      : 0x3ffb1f60  ",
+				"",
+				"Backtrace: 0x400d0b4c:0x3ffb1f60 0x400d0b49:0x3ffb1fa0",
+				"",
+				"Rebooting...",
+			},
+			[]boardPanic{{
+				Reason:    "LoadProhibited",
+				Core:      "0",
+				Registers: map[string]string{"PC": "0x400d0b4c", "PS": "0x00060030", "A0": "0x800d0b4c", "A1": "0x3ffb1f60"},
+				Backtrace: []backtraceFrame{{PC: "0x400d0b4c", SP: "0x3ffb1f60"}, {PC: "0x400d0b49", SP: "0x3ffb1fa0"}},
+			}},
+		},
+		{
+			"old guru meditation",
+			[]string{
+				"Guru Meditation Error of type StoreProhibited occurred on core  1. Exception was unhandled.",
+				"Register dump:",
+				"PC      : 0x400d1234  PS      : 0x00060330",
+				"Backtrace: 0x400d1234:0x3ffb1f60",
+			},
+			[]boardPanic{{
+				Reason:    "StoreProhibited",
+				Core:      "1",
+				Registers: map[string]string{"PC": "0x400d1234", "PS": "0x00060330"},
+				Backtrace: []backtraceFrame{{PC: "0x400d1234", SP: "0x3ffb1f60"}},
+			}},
+		},
+		{
+			"abort",
+			[]string{
+				"abort() was called at PC 0x400d2000 on core 0",
+				"",
+				"Backtrace: 0x400d2000:0x3ffb1f60",
+			},
+			[]boardPanic{{
+				Reason:    "abort() was called at PC 0x400d2000",
+				Core:      "0",
+				Registers: map[string]string{},
+				Backtrace: []backtraceFrame{{PC: "0x400d2000", SP: "0x3ffb1f60"}},
+			}},
+		},
+		{
+			"without backtrace",
+			[]string{
+				"Guru Meditation Error: Core  0 panic'ed (IllegalInstruction). Exception was unhandled.",
+				"PC      : 0x400d0b4c",
+				"rst:0xc (SW_CPU_RESET),boot:0x13 (SPI_FAST_FLASH_BOOT)",
+			},
+			[]boardPanic{{
+				Reason:    "IllegalInstruction",
+				Core:      "0",
+				Registers: map[string]string{"PC": "0x400d0b4c"},
+				Backtrace: []backtraceFrame{},
+			}},
+		},
+		{
+			"panic in progress",
+			[]string{
+				"Guru Meditation Error: Core  0 panic'ed (LoadProhibited). Exception was unhandled.",
+				"abort() was called at PC 0x400d2000 on core 1",
+				"Backtrace: 0x400d2000:0x3ffb1f60",
+			},
+			[]boardPanic{{
+				Reason:    "LoadProhibited",
+				Core:      "0",
+				Registers: map[string]string{},
+				Backtrace: []backtraceFrame{},
+			}, {
+				Reason:    "abort() was called at PC 0x400d2000",
+				Core:      "1",
+				Registers: map[string]string{},
+				Backtrace: []backtraceFrame{{PC: "0x400d2000", SP: "0x3ffb1f60"}},
+			}},
+		},
+		{
+			"not a panic",
+			[]string{
+				"PC      : 0x400d0b4c",
+				"Backtrace: 0x400d0b4c:0x3ffb1f60",
+				"Rebooting...",
+			},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		var panics []boardPanic
+
+		p := panicParser{}
+
+		for _, line := range test.lines {
+			if bp := p.parse(line); bp != nil {
+				panics = append(panics, *bp)
+			}
+		}
+
+		if !reflect.DeepEqual(panics, test.panics) {
+			t.Errorf("%s: panics are %+v, expected %+v", test.name, panics, test.panics)
+		}
+	}
+}
+
+func TestPanicDecodedBacktrace(t *testing.T) {
+	bp := boardPanic{
+		Backtrace: []backtraceFrame{
+			{PC: "0x400d0b4c", SP: "0x3ffb1f60", Function: "foo", File: "main/foo.c", Line: 12},
+			{PC: "0x400d0b49", SP: "0x3ffb1f80"},
+			{PC: "0x400d0b50", SP: "0x3ffb1fa0", Function: "bar"},
+		},
+	}
+
+	expected := "0x400d0b4c: foo at main/foo.c:12\r\n0x400d0b50: bar at ??:?\r\n"
+
+	if text := bp.decodedBacktrace(); text != expected {
+		t.Errorf("decoded backtrace is %q, expected %q", text, expected)
+	}
+}
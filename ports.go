@@ -0,0 +1,264 @@
+/*
+ * Whitecat Console, serial port discovery
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mikepb/go-serial"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A USB-UART bridge used in boards
+type usbBridge struct {
+	vid         int
+	pid         int
+	description string
+}
+
+// USB-UART bridges used in known boards
+var knownBridges = []usbBridge{
+	{0x10c4, 0xea60, "Silicon Labs CP210x"},
+	{0x0403, 0x6001, "FTDI FT232R"},
+	{0x0403, 0x6010, "FTDI FT2232"},
+	{0x0403, 0x6014, "FTDI FT232H"},
+	{0x0403, 0x6015, "FTDI FT231X"},
+	{0x1a86, 0x7523, "WCH CH340"},
+	{0x1a86, 0x55d4, "WCH CH9102"},
+}
+
+// Get the USB-UART bridge of a serial port, or nil if the serial port
+// is not a known USB-UART bridge
+func portBridge(info *serial.Info) *usbBridge {
+	if info.Transport() != serial.TRANSPORT_USB {
+		return nil
+	}
+
+	vid, pid, err := info.USBVIDPID()
+	if err != nil {
+		return nil
+	}
+
+	for i, bridge := range knownBridges {
+		if bridge.vid == vid && bridge.pid == pid {
+			return &knownBridges[i]
+		}
+	}
+
+	return nil
+}
+
+// Information about a serial port
+type PortInfo struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Transport    string `json:"transport"`
+	VIDPID       string `json:"vidpid,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Product      string `json:"product,omitempty"`
+	SerialNumber string `json:"serialNumber,omitempty"`
+	Board        bool   `json:"board"`
+	Bridge       string `json:"bridge,omitempty"`
+}
+
+func getPortInfo(info *serial.Info) PortInfo {
+	port := PortInfo{
+		Name:        info.Name(),
+		Description: info.Description(),
+	}
+
+	switch info.Transport() {
+	case serial.TRANSPORT_USB:
+		port.Transport = "usb"
+
+		if vid, pid, err := info.USBVIDPID(); err == nil {
+			port.VIDPID = fmt.Sprintf("%04x:%04x", vid, pid)
+		}
+
+		port.Manufacturer = info.USBManufacturer()
+		port.Product = info.USBProduct()
+		port.SerialNumber = info.USBSerialNumber()
+	case serial.TRANSPORT_BLUETOOTH:
+		port.Transport = "bluetooth"
+	default:
+		port.Transport = "native"
+	}
+
+	if bridge := portBridge(info); bridge != nil {
+		port.Board = true
+		port.Bridge = bridge.description
+	}
+
+	return port
+}
+
+// List all available serial ports, in text or JSON format. Ports that
+// look like a board are marked.
+func list_ports(asJSON bool) error {
+	ports := []PortInfo{}
+
+	// Enumerate all serial ports
+	infos, err := serial.ListPorts()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		ports = append(ports, getPortInfo(info))
+	}
+
+	if asJSON {
+		out, _ := json.MarshalIndent(ports, "", "  ")
+		fmt.Println(string(out))
+
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "PORT\tDESCRIPTION\tTRANSPORT\tVID:PID\tMANUFACTURER\tPRODUCT\tSERIAL NUMBER\tBOARD")
+
+	for _, port := range ports {
+		board := ""
+		if port.Board {
+			board = "yes (" + port.Bridge + ")"
+		}
+
+		fmt.Fprintln(w, port.Name+"\t"+port.Description+"\t"+port.Transport+"\t"+port.VIDPID+"\t"+
+			port.Manufacturer+"\t"+port.Product+"\t"+port.SerialNumber+"\t"+board)
+	}
+
+	return w.Flush()
+}
+
+// Get the USB serial number of a serial port, or an empty string if the
+// port is not an USB port, or hasn't a serial number
+func portSerialNumber(name string) string {
+	ports, err := serial.ListPorts()
+	if err != nil {
+		return ""
+	}
+
+	for _, info := range ports {
+		if info.Name() == name && info.Transport() == serial.TRANSPORT_USB {
+			return info.USBSerialNumber()
+		}
+	}
+
+	return ""
+}
+
+// Find the serial port of the USB device with a serial number. Returns an
+// empty string if the device is not connected.
+func findPortBySerialNumber(serialNumber string) string {
+	ports, err := serial.ListPorts()
+	if err != nil {
+		return ""
+	}
+
+	for _, info := range ports {
+		if info.Transport() == serial.TRANSPORT_USB && info.USBSerialNumber() == serialNumber {
+			return info.Name()
+		}
+	}
+
+	return ""
+}
+
+// Test if port name means that the port must be auto detected
+func isAutoPort(name string) bool {
+	return name == "auto" || strings.HasPrefix(name, "auto:")
+}
+
+// Find the port of a connected board. If name is auto:serial, only the
+// board with this USB serial number is selected. If there are many boards,
+// user must choose one.
+func findBoardPort(name string) (string, error) {
+	serialNumber := strings.TrimPrefix(strings.TrimPrefix(name, "auto"), ":")
+
+	ports, err := serial.ListPorts()
+	if err != nil {
+		return "", classify(exitNoPort, "", err)
+	}
+
+	candidates := []*serial.Info{}
+
+	for _, info := range ports {
+		if portBridge(info) == nil {
+			continue
+		}
+
+		if serialNumber != "" && info.USBSerialNumber() != serialNumber {
+			continue
+		}
+
+		candidates = append(candidates, info)
+	}
+
+	if len(candidates) == 0 {
+		if serialNumber != "" {
+			return "", classify(exitNoPort, "", errors.New("no board found with serial number "+serialNumber))
+		}
+
+		return "", classify(exitNoPort, "", errors.New("no board found"))
+	} else if len(candidates) == 1 {
+		log.Println("board found at", candidates[0].Name())
+
+		return candidates[0].Name(), nil
+	}
+
+	// Many boards found, user must choose one
+	okayPorts := []string{}
+
+	fmt.Print("\nMany boards found, please, enter your board:\n\n")
+
+	for option, info := range candidates {
+		okayPorts = append(okayPorts, strconv.Itoa(option+1))
+		fmt.Printf("% 3d: %s (%s, serial number %s)\n", option+1, info.Name(), portBridge(info).description, info.USBSerialNumber())
+	}
+
+	fmt.Print("\nBoard: ")
+
+	selectedPort := ""
+
+	_, err = fmt.Scanln(&selectedPort)
+	if err == nil && containsString(okayPorts, selectedPort) {
+		option, _ := strconv.Atoi(selectedPort)
+
+		return candidates[option-1].Name(), nil
+	}
+
+	return "", classify(exitNoPort, "", errors.New("no board selected"))
+}
@@ -0,0 +1,161 @@
+/*
+ * Whitecat Console, board reset
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Configuration used for attach a board
+type AttachConfig struct {
+	// Baud rate
+	BaudRate int `yaml:"baud"`
+
+	// Reset sequence name, see resetSequences
+	Reset string `yaml:"reset"`
+
+	// Pulse time in the reset sequence, in milliseconds
+	Pulse int `yaml:"pulse"`
+
+	// Firmware installed in the board, used for find the firmware's ELF file
+	// when the board is not queried, for example in the monitor
+	Firmware string `yaml:"firmware"`
+}
+
+var defaultAttachConfig = AttachConfig{
+	BaudRate: 115200,
+	Reset:    "rts",
+	Pulse:    10,
+}
+
+// Attach configuration for board types that don't work with the default
+// configuration. More board types can be defined in the boards section of
+// the configuration file.
+var boardAttachConfigs = map[string]AttachConfig{
+	"ESP32-THING":   {BaudRate: 115200, Reset: "esptool", Pulse: 100},
+	"ESP32-GATEWAY": {BaudRate: 115200, Reset: "esptool", Pulse: 100},
+}
+
+// Current attach configuration
+var attachConfig = defaultAttachConfig
+
+// A step in a reset sequence
+type resetStep func(port Transport, pulse time.Duration)
+
+func setRTS(on bool) resetStep {
+	return func(port Transport, pulse time.Duration) {
+		port.SetRTS(on)
+	}
+}
+
+func setDTR(on bool) resetStep {
+	return func(port Transport, pulse time.Duration) {
+		port.SetDTR(on)
+	}
+}
+
+func waitPulse(port Transport, pulse time.Duration) {
+	time.Sleep(pulse)
+}
+
+// Reset sequences. In most boards RTS is connected to the EN pin of the
+// ESP32, through a transistor, so EN is low when RTS is on. The none
+// sequence doesn't reset the board.
+var resetSequences = map[string][]resetStep{
+	"rts":          {setRTS(false), waitPulse, setRTS(true), waitPulse, setRTS(false)},
+	"rts-inverted": {setRTS(true), waitPulse, setRTS(false), waitPulse, setRTS(true)},
+	"dtr":          {setDTR(false), waitPulse, setDTR(true), waitPulse, setDTR(false)},
+	"dtr-inverted": {setDTR(true), waitPulse, setDTR(false), waitPulse, setDTR(true)},
+
+	// Same sequence used by esptool for a hard reset in boards with the
+	// DTR / RTS auto reset circuit, where RTS drives EN and DTR drives IO0.
+	// DTR is kept off, so IO0 is high and the board boots from flash.
+	"esptool": {setDTR(false), setRTS(true), waitPulse, setRTS(false), waitPulse},
+
+	"none": {},
+}
+
+// Get the names of the available reset sequences
+func resetSequenceNames() []string {
+	names := []string{}
+
+	for name := range resetSequences {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Get the attach configuration for a board type. Board type is case
+// insensitive.
+func boardAttachConfig(boardType string) (AttachConfig, error) {
+	names := []string{}
+
+	for name, config := range boardAttachConfigs {
+		if strings.EqualFold(name, boardType) {
+			return config, nil
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return AttachConfig{}, errors.New("unknown board type " + boardType + ", use one of " + strings.Join(names, ", "))
+}
+
+// Check the attach configuration
+func (config AttachConfig) check() error {
+	if config.BaudRate <= 0 {
+		return errors.New("invalid baud rate")
+	}
+
+	if config.Pulse < 0 {
+		return errors.New("invalid pulse time")
+	}
+
+	if _, ok := resetSequences[config.Reset]; !ok {
+		return errors.New("unknown reset sequence " + config.Reset + ", use one of " + strings.Join(resetSequenceNames(), ", "))
+	}
+
+	return nil
+}
+
+// Run the reset sequence in a port
+func (config AttachConfig) resetPort(port Transport) {
+	for _, step := range resetSequences[config.Reset] {
+		step(port, time.Millisecond*time.Duration(config.Pulse))
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Whitecat Console, board reset tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// A transport that records the changes in the control lines
+type lineRecorder struct {
+	changes []string
+}
+
+func (r *lineRecorder) Read(b []byte) (int, error)  { return 0, nil }
+func (r *lineRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (r *lineRecorder) Close() error                { return nil }
+func (r *lineRecorder) HasControlLines() bool       { return true }
+
+func (r *lineRecorder) SetRTS(on bool) error {
+	r.changes = append(r.changes, lineChange("RTS", on))
+	return nil
+}
+
+func (r *lineRecorder) SetDTR(on bool) error {
+	r.changes = append(r.changes, lineChange("DTR", on))
+	return nil
+}
+
+func lineChange(line string, on bool) string {
+	if on {
+		return line + " on"
+	}
+
+	return line + " off"
+}
+
+func TestResetSequences(t *testing.T) {
+	tests := []struct {
+		reset   string
+		changes []string
+	}{
+		{"rts", []string{"RTS off", "RTS on", "RTS off"}},
+		{"rts-inverted", []string{"RTS on", "RTS off", "RTS on"}},
+		{"dtr", []string{"DTR off", "DTR on", "DTR off"}},
+		{"dtr-inverted", []string{"DTR on", "DTR off", "DTR on"}},
+		{"esptool", []string{"DTR off", "RTS on", "RTS off"}},
+		{"none", nil},
+	}
+
+	for _, test := range tests {
+		r := &lineRecorder{}
+
+		AttachConfig{BaudRate: 115200, Reset: test.reset}.resetPort(r)
+
+		if !reflect.DeepEqual(r.changes, test.changes) {
+			t.Errorf("%s: changes are %v, expected %v", test.reset, r.changes, test.changes)
+		}
+	}
+
+	if len(tests) != len(resetSequences) {
+		t.Errorf("%d reset sequences tested, expected %d", len(tests), len(resetSequences))
+	}
+}
+
+func TestResetSequencesBoot(t *testing.T) {
+	tests := []struct {
+		wiring string
+		reset  string
+	}{
+		{"auto", "rts"},
+		{"auto", "esptool"},
+		{"rts", "rts"},
+		{"rts", "esptool"},
+		{"rts-inverted", "rts-inverted"},
+		{"dtr", "dtr"},
+		{"dtr-inverted", "dtr-inverted"},
+	}
+
+	for _, test := range tests {
+		sim, err := openSimTransport("sim://?wiring=" + test.wiring)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		AttachConfig{BaudRate: 115200, Reset: test.reset, Pulse: 10}.resetPort(sim)
+
+		if state := simState(sim); state != simStateBooting {
+			t.Errorf("%s with %s wiring: state is %d, expected the board booting", test.reset, test.wiring, state)
+		}
+
+		sim.Close()
+	}
+}
+
+func TestBoardAttachConfig(t *testing.T) {
+	tests := []struct {
+		boardType string
+		config    AttachConfig
+		ok        bool
+	}{
+		{"ESP32-THING", AttachConfig{BaudRate: 115200, Reset: "esptool", Pulse: 100}, true},
+		{"esp32-gateway", AttachConfig{BaudRate: 115200, Reset: "esptool", Pulse: 100}, true},
+		{"ESP32-CORE-BOARD", AttachConfig{}, false},
+	}
+
+	for _, test := range tests {
+		config, err := boardAttachConfig(test.boardType)
+
+		if test.ok && err != nil {
+			t.Errorf("%s: failed: %v", test.boardType, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: configuration is %+v, expected an error", test.boardType, config)
+		} else if config != test.config {
+			t.Errorf("%s: configuration is %+v, expected %+v", test.boardType, config, test.config)
+		}
+	}
+}
+
+func TestConfigBoards(t *testing.T) {
+	builtIn := boardAttachConfigs
+	defer func() { boardAttachConfigs = builtIn }()
+
+	boardAttachConfigs = map[string]AttachConfig{}
+	for name, config := range builtIn {
+		boardAttachConfigs[name] = config
+	}
+
+	c := defaultConfig()
+	c.Boards = map[string]AttachConfig{
+		"esp32-thing": {Reset: "rts-inverted"},
+		"custom":      {BaudRate: 921600, Pulse: 50},
+	}
+	c.apply()
+
+	tests := []struct {
+		boardType string
+		config    AttachConfig
+	}{
+		{"ESP32-THING", AttachConfig{BaudRate: 115200, Reset: "rts-inverted", Pulse: 10}},
+		{"ESP32-GATEWAY", AttachConfig{BaudRate: 115200, Reset: "esptool", Pulse: 100}},
+		{"custom", AttachConfig{BaudRate: 921600, Reset: "rts", Pulse: 50}},
+	}
+
+	for _, test := range tests {
+		if config, err := boardAttachConfig(test.boardType); err != nil || config != test.config {
+			t.Errorf("%s: configuration is %+v (%v), expected %+v", test.boardType, config, err, test.config)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+/*
+ * Whitecat Console, RFC 2217 transport
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// RFC 2217 commands, sent from client to server. Server answers using
+// the same command plus 100.
+const (
+	rfc2217SetBaudRate = 1
+	rfc2217SetDataSize = 2
+	rfc2217SetParity   = 3
+	rfc2217SetStopSize = 4
+	rfc2217SetControl  = 5
+
+	rfc2217ServerOffset = 100
+)
+
+// RFC 2217 values for the SET-CONTROL command
+const (
+	rfc2217ControlNoFlow = 1
+	rfc2217ControlDTROn  = 8
+	rfc2217ControlDTROff = 9
+	rfc2217ControlRTSOn  = 11
+	rfc2217ControlRTSOff = 12
+)
+
+// Time waiting for the server answer to the SET-BAUDRATE command
+const rfc2217AnswerTimeout = time.Second * 2
+
+// Transport for boards connected to a remote serial port, shared through a
+// RFC 2217 server, such as ser2net. This is a telnet connection with the
+// COM-PORT option enabled, that allows to configure the remote serial port
+// and to drive the control lines.
+type rfc2217Transport struct {
+	*netTransport
+
+	// Baud rate answered by the server to the SET-BAUDRATE command
+	baudRate chan uint32
+
+	// Data received while waiting for the server answer, that is returned
+	// by the next reads
+	pending []byte
+}
+
+func openRFC2217Transport(address string, baudRate int) (*rfc2217Transport, error) {
+	conn, err := openNetTransport(address, true)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &rfc2217Transport{netTransport: conn, baudRate: make(chan uint32, 1)}
+
+	if err := t.setup(baudRate); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Configure remote serial port: baud rate, 8 data bits, no parity, 1 stop
+// bit, without flow control, and control lines off
+func (t *rfc2217Transport) setup(baudRate int) error {
+	t.telnet.subnegotiation = t.subnegotiation
+
+	if err := t.telnet.will(telnetOptComPort); err != nil {
+		return err
+	}
+
+	speed := make([]byte, 4)
+	binary.BigEndian.PutUint32(speed, uint32(baudRate))
+
+	commands := [][]byte{
+		append([]byte{rfc2217SetBaudRate}, speed...),
+		{rfc2217SetDataSize, 8},
+		{rfc2217SetParity, 1},
+		{rfc2217SetStopSize, 1},
+		{rfc2217SetControl, rfc2217ControlNoFlow},
+		{rfc2217SetControl, rfc2217ControlDTROff},
+		{rfc2217SetControl, rfc2217ControlRTSOff},
+	}
+
+	for _, command := range commands {
+		if err := t.command(command[0], command[1:]...); err != nil {
+			return err
+		}
+	}
+
+	return t.waitBaudRate(uint32(baudRate))
+}
+
+// Wait for the server answer to the SET-BAUDRATE command. Servers that
+// don't answer are accepted, but a different baud rate is an error.
+func (t *rfc2217Transport) waitBaudRate(baudRate uint32) error {
+	t.conn.SetReadDeadline(time.Now().Add(rfc2217AnswerTimeout))
+	defer t.conn.SetReadDeadline(time.Time{})
+
+	raw := make([]byte, 256)
+	data := make([]byte, len(raw))
+
+	for {
+		n, err := t.conn.Read(raw)
+
+		n = t.telnet.decode(data, raw[:n])
+		t.pending = append(t.pending, data[:n]...)
+
+		select {
+		case answer := <-t.baudRate:
+			if answer != baudRate {
+				return fmt.Errorf("remote serial port baud rate is %d, expected %d", answer, baudRate)
+			}
+
+			return nil
+		default:
+		}
+
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			log.Println("the server doesn't answer to the baud rate, assuming", baudRate)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *rfc2217Transport) Read(b []byte) (int, error) {
+	if len(t.pending) > 0 {
+		n := copy(b, t.pending)
+		t.pending = t.pending[n:]
+
+		return n, nil
+	}
+
+	return t.netTransport.Read(b)
+}
+
+// Send a COM-PORT command to the server
+func (t *rfc2217Transport) command(cmd byte, value ...byte) error {
+	return t.telnet.sendSubnegotiation(telnetOptComPort, append([]byte{cmd}, value...))
+}
+
+// Process a subnegotiation received from the server
+func (t *rfc2217Transport) subnegotiation(option byte, data []byte) {
+	if option != telnetOptComPort || len(data) == 0 {
+		return
+	}
+
+	switch data[0] {
+	case rfc2217ServerOffset + rfc2217SetBaudRate:
+		if len(data) == 5 {
+			baudRate := binary.BigEndian.Uint32(data[1:])
+
+			log.Println("remote serial port baud rate is", baudRate)
+
+			select {
+			case t.baudRate <- baudRate:
+			default:
+			}
+		}
+	}
+}
+
+func (t *rfc2217Transport) SetRTS(on bool) error {
+	if on {
+		return t.command(rfc2217SetControl, rfc2217ControlRTSOn)
+	}
+
+	return t.command(rfc2217SetControl, rfc2217ControlRTSOff)
+}
+
+func (t *rfc2217Transport) SetDTR(on bool) error {
+	if on {
+		return t.command(rfc2217SetControl, rfc2217ControlDTROn)
+	}
+
+	return t.command(rfc2217SetControl, rfc2217ControlDTROff)
+}
+
+func (t *rfc2217Transport) HasControlLines() bool {
+	return true
+}
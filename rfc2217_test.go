@@ -0,0 +1,212 @@
+/*
+ * Whitecat Console, RFC 2217 client tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Subnegotiation of a COM-PORT command, as sent to the server
+func comPortCommand(data ...byte) []byte {
+	return append(append([]byte{telnetIAC, telnetSB, telnetOptComPort}, data...), telnetIAC, telnetSE)
+}
+
+// Answer of the server to the SET-BAUDRATE command
+func baudRateAnswer(baudRate int) []byte {
+	speed := make([]byte, 4)
+	binary.BigEndian.PutUint32(speed, uint32(baudRate))
+
+	speed = newTelnet(nil).encode(speed)
+
+	return append(append([]byte{telnetIAC, telnetSB, telnetOptComPort, rfc2217ServerOffset + rfc2217SetBaudRate}, speed...), telnetIAC, telnetSE)
+}
+
+// Open a RFC 2217 transport, and call use with it. The server sends answer
+// when the client connects, and the first length bytes received by the
+// server are returned, with the error of the open.
+func rfc2217Exchange(t *testing.T, baudRate int, answer []byte, length int, use func(tr *rfc2217Transport)) ([]byte, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan []byte, 1)
+	done := make(chan struct{})
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second * 4))
+
+		conn.Write(answer)
+
+		b := make([]byte, length)
+		n, _ := io.ReadFull(conn, b)
+		received <- b[:n]
+
+		<-done
+	}()
+
+	defer close(done)
+
+	tr, err := openRFC2217Transport(l.Addr().String(), baudRate)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Close()
+
+	if use != nil {
+		use(tr)
+	}
+
+	return <-received, nil
+}
+
+// Bytes sent by the client when the transport is opened
+func rfc2217Setup(speed []byte) []byte {
+	b := []byte{
+		telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptComPort,
+	}
+
+	b = append(b, comPortCommand(append([]byte{rfc2217SetBaudRate}, speed...)...)...)
+	b = append(b, comPortCommand(rfc2217SetDataSize, 8)...)
+	b = append(b, comPortCommand(rfc2217SetParity, 1)...)
+	b = append(b, comPortCommand(rfc2217SetStopSize, 1)...)
+	b = append(b, comPortCommand(rfc2217SetControl, rfc2217ControlNoFlow)...)
+	b = append(b, comPortCommand(rfc2217SetControl, rfc2217ControlDTROff)...)
+	b = append(b, comPortCommand(rfc2217SetControl, rfc2217ControlRTSOff)...)
+
+	return b
+}
+
+func TestRFC2217Negotiation(t *testing.T) {
+	tests := []struct {
+		baudRate int
+		speed    []byte
+	}{
+		{115200, []byte{0x00, 0x01, 0xc2, 0x00}},
+		{921600, []byte{0x00, 0x0e, 0x10, 0x00}},
+		{255, []byte{0x00, 0x00, 0x00, telnetIAC, telnetIAC}},
+	}
+
+	for _, test := range tests {
+		expected := rfc2217Setup(test.speed)
+
+		received, err := rfc2217Exchange(t, test.baudRate, baudRateAnswer(test.baudRate), len(expected), nil)
+		if err != nil {
+			t.Errorf("%d: open failed: %v", test.baudRate, err)
+		} else if !bytes.Equal(received, expected) {
+			t.Errorf("%d: negotiation is %v, expected %v", test.baudRate, received, expected)
+		}
+	}
+}
+
+func TestRFC2217BaudRateAnswer(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer []byte
+		data   string
+		ok     bool
+	}{
+		{"answer", baudRateAnswer(115200), "", true},
+		{"data before answer", append([]byte("rst:0x1\r\n"), baudRateAnswer(115200)...), "rst:0x1\r\n", true},
+		{"other baud rate", baudRateAnswer(9600), "", false},
+		{"no answer", nil, "", true},
+	}
+
+	length := len(rfc2217Setup([]byte{0x00, 0x01, 0xc2, 0x00}))
+
+	for _, test := range tests {
+		var data []byte
+
+		_, err := rfc2217Exchange(t, 115200, test.answer, length, func(tr *rfc2217Transport) {
+			data = append([]byte{}, tr.pending...)
+		})
+
+		if test.ok != (err == nil) {
+			t.Errorf("%s: error is %v", test.name, err)
+		}
+
+		if string(data) != test.data {
+			t.Errorf("%s: data is %q, expected %q", test.name, data, test.data)
+		}
+	}
+}
+
+func TestRFC2217ControlLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(tr *rfc2217Transport) error
+		control byte
+	}{
+		{"RTS on", func(tr *rfc2217Transport) error { return tr.SetRTS(true) }, rfc2217ControlRTSOn},
+		{"RTS off", func(tr *rfc2217Transport) error { return tr.SetRTS(false) }, rfc2217ControlRTSOff},
+		{"DTR on", func(tr *rfc2217Transport) error { return tr.SetDTR(true) }, rfc2217ControlDTROn},
+		{"DTR off", func(tr *rfc2217Transport) error { return tr.SetDTR(false) }, rfc2217ControlDTROff},
+	}
+
+	for _, test := range tests {
+		expected := append(rfc2217Setup([]byte{0x00, 0x01, 0xc2, 0x00}), comPortCommand(rfc2217SetControl, test.control)...)
+
+		received, err := rfc2217Exchange(t, 115200, baudRateAnswer(115200), len(expected), func(tr *rfc2217Transport) {
+			if err := test.set(tr); err != nil {
+				t.Errorf("%s: failed: %v", test.name, err)
+			}
+
+			// A dead link is an error
+			tr.Close()
+
+			if err := test.set(tr); err == nil {
+				t.Errorf("%s: no error with the connection closed", test.name)
+			}
+		})
+
+		if err != nil {
+			t.Errorf("%s: open failed: %v", test.name, err)
+		} else if !bytes.Equal(received, expected) {
+			t.Errorf("%s: received %v, expected %v", test.name, received, expected)
+		}
+	}
+}
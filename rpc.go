@@ -0,0 +1,188 @@
+/*
+ * Whitecat Console, framed RPC
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Lua code used for get the board information
+const luaBoardInfo = `local t, s, b = os.board() print(t) print(s or "") print(b or "")`
+
+// Lua code used for get the firmware commit
+const luaFirmwareCommit = `local _, _, _, c = os.version() print(c)`
+
+// Sequence number of the last RPC call, used in the call markers. Updated
+// atomically, as calls can be done from the reconnect goroutines.
+var rpcSequence int32
+
+// Build the Lua command for a RPC call. Code is loaded as an expression if
+// possible, or as a statement if not, and run in protected mode. Its output
+// is enclosed between a begin and an end marker. The end marker contains
+// the exit status, and the error message if the code raised an error, or
+// if the code returned nil and an error message, as the os functions do
+// when they fail. Markers are built at runtime, so the echo of the command
+// never contains them.
+func rpcCommand(sequence int32, code string) string {
+	seq := strconv.Itoa(int(sequence))
+
+	return `do print("<wcc" .. ":begin:` + seq + `>") ` +
+		`local c = ` + luaQuote(code) + ` ` +
+		`local f, e = load("return " .. c, "=rpc") ` +
+		`if not f then f, e = load(c, "=rpc") end ` +
+		`local ok = f ~= nil ` +
+		`if ok then ` +
+		`local r = table.pack(pcall(f)) ` +
+		`ok, e = r[1], r[2] ` +
+		`if ok and r.n > 2 and r[2] == nil then ok, e = false, r[3] end ` +
+		`end ` +
+		`print("<wcc" .. ":end:` + seq + `:" .. (ok and "0" or "1") .. ":" .. (ok and "" or (tostring(e):gsub("[\r\n]", " "))) .. ">") ` +
+		`end`
+}
+
+// Call Lua code in the board, using framed RPC, instead of parsing the
+// board's prompt. Returns the output of the code, or an error if the code
+// raised a Lua error, or returned nil and an error message.
+func (board *Board) call(code string) (string, error) {
+	return board.callTimeout(code, config.Timeouts.Command)
+}
+
+// Call Lua code in the board, as call, waiting up to timeout milliseconds
+// for each response. Used for the code that works with the content of
+// files, that can take long on big files.
+func (board *Board) callTimeout(code string, timeout int) (string, error) {
+	var output []string
+
+	defer func() {
+		board.noTimeout()
+		board.consoleOut = true
+		board.consoleIn = false
+	}()
+
+	board.consoleOut = false
+	board.consoleIn = true
+
+	board.timeout(timeout)
+
+	sequence := atomic.AddInt32(&rpcSequence, 1)
+
+	begin := "<wcc:begin:" + strconv.Itoa(int(sequence)) + ">"
+	end := regexp.MustCompile(`^<wcc:end:` + strconv.Itoa(int(sequence)) + `:(\d+):(.*)>$`)
+
+	board.port.Write([]byte(rpcCommand(sequence, code) + "\r\n"))
+
+	// Skip all until the begin marker, including the echo
+	for {
+		line, err := board.readLineCRLF()
+		if err != nil {
+			return "", err
+		}
+
+		if line == begin {
+			break
+		}
+	}
+
+	// Read output until the end marker
+	for {
+		line, err := board.readLineCRLF()
+		if err != nil {
+			return "", err
+		}
+
+		if parts := end.FindStringSubmatch(line); parts != nil {
+			// Skip the prompt
+			for !isPrompt(line) {
+				line, err = board.readLineCRLF()
+				if err != nil {
+					return "", err
+				}
+			}
+
+			if parts[1] != "0" {
+				log.Println("rpc error:", parts[2])
+
+				return strings.Join(output, "\r\n"), errors.New(parts[2])
+			}
+
+			return strings.Join(output, "\r\n"), nil
+		}
+
+		output = append(output, line)
+	}
+}
+
+// Get the board model, subtype and brand, and build the firmware name
+func (board *Board) getBoardInfo() error {
+	response, err := board.call(luaBoardInfo)
+	if err != nil {
+		return err
+	}
+
+	info := strings.Split(response, "\r\n")
+	if len(info) != 3 {
+		return errors.New("invalid board information: " + response)
+	}
+
+	board.model = info[0]
+	board.subtype = info[1]
+	board.brand = info[2]
+
+	firmware := ""
+
+	if board.brand != "" {
+		firmware = board.brand + "-"
+	}
+
+	firmware = firmware + board.model
+
+	if board.subtype != "" {
+		firmware = firmware + "-" + board.subtype
+	}
+
+	board.firmware = firmware
+
+	return nil
+}
+
+// Get the commit of the board's firmware
+func (board *Board) getFirmwareCommit() (string, error) {
+	return board.call(luaFirmwareCommit)
+}
+
+// List a directory in the board, in the os.ls format
+func (board *Board) ls(path string) (string, error) {
+	return board.call("os.ls(" + luaQuote(path) + ")")
+}
@@ -0,0 +1,147 @@
+/*
+ * Whitecat Console, run scripts
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Path in the board where scripts are uploaded before run them
+const runScriptPath = "/_wcc_run.lua"
+
+// Upload a local script to the board, run it, and stream its output until
+// the prompt returns. If timeout is not 0, the script is interrupted after
+// timeout seconds. Returns an error if the script raised a Lua runtime
+// error, or if the timeout expired.
+func (board *Board) runScript(src string, timeout int) error {
+	script, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	err = board.writeFile(runScriptPath, script)
+	if err != nil {
+		return err
+	}
+
+	notify("progress", "\033[K")
+
+	defer func() {
+		board.noTimeout()
+		board.consoleOut = true
+		board.consoleIn = false
+	}()
+
+	board.consume()
+
+	board.consoleOut = false
+	board.consoleIn = true
+
+	atomic.StoreInt32(&board.runtimeErrors, 0)
+
+	command := "dofile(" + luaQuote(runScriptPath) + ")"
+
+	// Send command, and skip the echo
+	board.port.Write([]byte(command + "\r\n"))
+
+	if err := board.streamUntilPrompt(command, timeout); err == errTimeout {
+		// Timeout, interrupt script
+		board.port.Write([]byte{3})
+		board.consume()
+
+		board.removeRunScript()
+
+		return classify(exitTimeout, "", fmt.Errorf("timeout, script interrupted after %d seconds", timeout))
+	} else if err != nil {
+		return err
+	}
+
+	board.removeRunScript()
+
+	if atomic.LoadInt32(&board.runtimeErrors) != 0 {
+		return errors.New("script raised a Lua error")
+	}
+
+	return nil
+}
+
+// Print the lines received from the board until the prompt. Returns
+// errTimeout if the timeout, in seconds, expired before the prompt, or the
+// error found reading the board.
+func (board *Board) streamUntilPrompt(command string, timeout int) error {
+	var deadline time.Time
+
+	if timeout > 0 {
+		deadline = time.Now().Add(time.Second * time.Duration(timeout))
+	}
+
+	echo := true
+
+	for {
+		if timeout > 0 {
+			remaining := deadline.Sub(time.Now())
+			if remaining <= 0 {
+				return errTimeout
+			}
+
+			board.timeout(int(remaining / time.Millisecond))
+		}
+
+		line, err := board.readLineCRLF()
+		if err != nil {
+			return err
+		}
+
+		if echo && line == command {
+			echo = false
+			continue
+		}
+
+		if isPrompt(line) {
+			return nil
+		}
+
+		fmt.Println(line)
+	}
+}
+
+// Remove the uploaded script
+func (board *Board) removeRunScript() {
+	if _, err := board.call("os.remove(" + luaQuote(runScriptPath) + ")"); err != nil {
+		log.Println("can't remove script:", err)
+	} else {
+		log.Println("script removed")
+	}
+}
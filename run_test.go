@@ -0,0 +1,77 @@
+/*
+ * Whitecat Console, run scripts tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		timeout int
+		detach  bool
+		code    int
+	}{
+		{"ok", "print(\"hello\")\n", 0, false, exitOk},
+		{"error", "print(\"hello\")\nerror(\"boom\")\n", 0, false, exitFailure},
+		{"timeout", "io.receive(\"/never.lua\")\n", 1, false, exitTimeout},
+		{"detached", "io.receive(\"/never.lua\")\n", 0, true, exitNoPort},
+	}
+
+	timeout := config.Timeouts.Command
+	config.Timeouts.Command = 500
+	defer func() { config.Timeouts.Command = timeout }()
+
+	for _, test := range tests {
+		script := filepath.Join(t.TempDir(), "script.lua")
+		if err := ioutil.WriteFile(script, []byte(test.script), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		board := openSimBoard(t)
+
+		if test.detach {
+			go func() {
+				time.Sleep(time.Millisecond * 500)
+				board.port.Close()
+			}()
+		}
+
+		err := board.runScript(script, test.timeout)
+		if code := exitCode(err); code != test.code {
+			t.Errorf("%s: exit code is %d, expected %d, error is %v", test.name, code, test.code, err)
+		}
+	}
+}
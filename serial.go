@@ -0,0 +1,93 @@
+/*
+ * Whitecat Console, serial port transport
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"github.com/mikepb/go-serial"
+)
+
+// Transport for boards connected to a local serial port
+type serialTransport struct {
+	port    *serial.Port
+	options serial.Options
+}
+
+func openSerialTransport(name string, baudRate int) (*serialTransport, error) {
+	// Configure options or serial port connection
+	options := serial.RawOptions
+	options.BitRate = baudRate
+	options.Mode = serial.MODE_READ_WRITE
+	options.DTR = serial.DTR_OFF
+	options.RTS = serial.RTS_OFF
+
+	// Open port
+	port, err := options.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serialTransport{port: port, options: options}, nil
+}
+
+func (t *serialTransport) Read(b []byte) (int, error) {
+	return t.port.Read(b)
+}
+
+func (t *serialTransport) Write(b []byte) (int, error) {
+	return t.port.Write(b)
+}
+
+func (t *serialTransport) Close() error {
+	return t.port.Close()
+}
+
+func (t *serialTransport) SetRTS(on bool) error {
+	if on {
+		t.options.RTS = serial.RTS_ON
+	} else {
+		t.options.RTS = serial.RTS_OFF
+	}
+
+	return t.port.Apply(&t.options)
+}
+
+func (t *serialTransport) SetDTR(on bool) error {
+	if on {
+		t.options.DTR = serial.DTR_ON
+	} else {
+		t.options.DTR = serial.DTR_OFF
+	}
+
+	return t.port.Apply(&t.options)
+}
+
+func (t *serialTransport) HasControlLines() bool {
+	return true
+}
@@ -0,0 +1,1017 @@
+/*
+ * Whitecat Console, Lua RTOS board simulator
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Simulator states
+const (
+	simStateOff = iota
+	simStateBooting
+	simStateShell
+	simStateReceiving
+	simStateSending
+	simStateDownload
+)
+
+// Time from the rising edge of EN to the sampling of the IO0 strapping pin,
+// as done by the EN pin RC delay in the real boards
+const simStrappingDelay = time.Millisecond * 5
+
+// How the RTS and DTR lines drive the EN and IO0 pins of the simulated
+// board. Returns the level of EN and IO0, true for high. A line is on when
+// asserted.
+type simWiring func(rts bool, dtr bool) (en bool, io0 bool)
+
+// Wirings of the simulated board, selected with the wiring parameter of the
+// port name. auto is the DTR / RTS auto reset circuit of most boards, where
+// EN is low only when RTS is on and DTR is off, and IO0 is low only when DTR
+// is on and RTS is off. The others are boards with EN driven by one line.
+var simWirings = map[string]simWiring{
+	"auto": func(rts bool, dtr bool) (bool, bool) {
+		return !(rts && !dtr), !(dtr && !rts)
+	},
+	"rts": func(rts bool, dtr bool) (bool, bool) {
+		return !rts, true
+	},
+	"rts-inverted": func(rts bool, dtr bool) (bool, bool) {
+		return rts, true
+	},
+	"dtr": func(rts bool, dtr bool) (bool, bool) {
+		return !dtr, true
+	},
+	"dtr-inverted": func(rts bool, dtr bool) (bool, bool) {
+		return dtr, true
+	},
+}
+
+// A file, or directory, in the simulator file system
+type simFile struct {
+	dir     bool
+	content []byte
+	modTime time.Time
+}
+
+// A command understood by the simulator. The simulator can't run Lua code,
+// so it only understands the Lua snippets sent by the console, plus some
+// common expressions.
+type simCommand struct {
+	re  *regexp.Regexp
+	run func(sim *simTransport, args []string)
+}
+
+var simCommands []simCommand
+
+func init() {
+	simCommands = []simCommand{
+		{regexp.MustCompile(`^os\.shell\((true|false)\)$`), func(sim *simTransport, args []string) {
+		}},
+		{regexp.MustCompile(`^do print\("<wcc" \.\. ":begin:(\d+)>"\) local c = (".*") local f, e = load\("return " \.\. c, "=rpc"\) .* end$`), func(sim *simTransport, args []string) {
+			sim.rpc(args[1], simString(args[2]))
+		}},
+		{regexp.MustCompile("^" + regexp.QuoteMeta(luaBoardInfo) + "$"), func(sim *simTransport, args []string) {
+			sim.println(sim.model)
+			sim.println(sim.subtype)
+			sim.println(sim.brand)
+		}},
+		{regexp.MustCompile("^" + regexp.QuoteMeta(luaFirmwareCommit) + "$"), func(sim *simTransport, args []string) {
+			sim.println(simCommit)
+		}},
+		{regexp.MustCompile(`^(?:=|return )?os\.version\(\)$`), func(sim *simTransport, args []string) {
+			sim.returns("Lua RTOS", "beta 0.1", simBuild, simCommit)
+		}},
+		{regexp.MustCompile(`^(?:=|return )?os\.board\(\)$`), func(sim *simTransport, args []string) {
+			sim.returns(sim.model, sim.subtype, sim.brand)
+		}},
+		{regexp.MustCompile(`^os\.ls\((".*")?\)$`), func(sim *simTransport, args []string) {
+			sim.ls(simString(args[1]))
+		}},
+		{regexp.MustCompile(`^io\.receive\((".*")\)$`), func(sim *simTransport, args []string) {
+			sim.receive(simString(args[1]))
+		}},
+		{regexp.MustCompile(`^io\.send\((".*")\)$`), func(sim *simTransport, args []string) {
+			sim.send(simString(args[1]))
+		}},
+		{regexp.MustCompile(`^os\.remove\((".*")\)$`), func(sim *simTransport, args []string) {
+			sim.remove(simString(args[1]))
+		}},
+		{regexp.MustCompile(`^os\.mkdir\((".*")\)$`), func(sim *simTransport, args []string) {
+			sim.mkdir(simString(args[1]))
+		}},
+		{regexp.MustCompile(`^os\.rename\((".*"), (".*")\)$`), func(sim *simTransport, args []string) {
+			sim.rename(simString(args[1]), simString(args[2]))
+		}},
+		{regexp.MustCompile(`^assert\(io\.open\((".*"), "a"\)\):close\(\)$`), func(sim *simTransport, args []string) {
+			sim.touch(simString(args[1]))
+		}},
+		{regexp.MustCompile(`^local function wcc_copy\(.* wcc_copy\((".*"), (".*")\)$`), func(sim *simTransport, args []string) {
+			sim.copy(simString(args[1]), simString(args[2]))
+		}},
+		{regexp.MustCompile(`^assert\((.*)\)$`), func(sim *simTransport, args []string) {
+			sim.asserted = true
+			sim.run(args[1])
+			sim.asserted = false
+		}},
+		{regexp.MustCompile(`^local c = (".*") local f = load\("return " \.\. c, "=stdin"\) or .*$`), func(sim *simTransport, args []string) {
+			sim.evalShell(simString(args[1]))
+		}},
+		{regexp.MustCompile(`^dofile\((".*")\)$`), func(sim *simTransport, args []string) {
+			sim.dofile(simString(args[1]))
+		}},
+		{regexp.MustCompile(`^local function wcc_json\(.* wcc_eval\((".*")\)$`), func(sim *simTransport, args []string) {
+			sim.evalJSON(args[1])
+		}},
+		{regexp.MustCompile(`^local function wcc_checksum\(.* wcc_checksum\((".*")\)$`), func(sim *simTransport, args []string) {
+			sim.checksum(simString(args[1]))
+		}},
+		{regexp.MustCompile(`^print\("(.*)"\)$`), func(sim *simTransport, args []string) {
+			sim.println(args[1])
+		}},
+		{regexp.MustCompile(`^error\("(.*)"\)$`), func(sim *simTransport, args []string) {
+			sim.error(args[1])
+		}},
+		{regexp.MustCompile(`^warn\("(.*)"\)$`), func(sim *simTransport, args []string) {
+			sim.println(sim.chunk + ":" + strconv.Itoa(sim.chunkLine) + ": WARNING " + args[1])
+		}},
+	}
+}
+
+// Get the value of a Lua string literal used as argument
+func simString(quoted string) string {
+	if s, err := luaUnquote(quoted); err == nil {
+		return s
+	}
+
+	return quoted
+}
+
+// Build and commit reported by the simulator
+var simBuild = "1525698462"
+var simCommit = "0000000000000000000000000000000000000000"
+
+// Transport for a simulated Lua RTOS board, used for develop and test
+// without hardware. The simulator emulates the boot log, the Lua RTOS
+// shell prompt, the board information functions, and the file transfer
+// functions, using an in-memory file system.
+type simTransport struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// Data sent from the board to the console
+	out    bytes.Buffer
+	closed bool
+
+	state  int
+	rts    bool
+	dtr    bool
+	wiring simWiring
+
+	// Level of the EN pin. The board runs when EN is high.
+	en bool
+
+	// Level of the IO0 pin sampled at reset, and the end of the sampling
+	// window. IO0 changes after the window are ignored.
+	io0      bool
+	strapEnd time.Time
+
+	// Incremented on each reset, for cancel pending boot timers
+	boots int
+
+	// Current line
+	line    []byte
+	lastCR  bool
+	newLine bool
+
+	// Board information
+	model   string
+	subtype string
+	brand   string
+
+	// File system
+	fs map[string]*simFile
+
+	// Chunk and line of the command in execution, used in messages, and
+	// true if the command failed
+	chunk     string
+	chunkLine int
+	failed    bool
+
+	// If true, the command in execution was typed in the shell, so the
+	// values returned by the command are printed
+	repl bool
+
+	// If true, the command in execution is enclosed in an assert, so it
+	// fails if it returns nil or false
+	asserted bool
+
+	// Values returned by the last command
+	returned []string
+
+	// If true, errors are not printed, and the last error message is
+	// stored in errorMessage
+	protected    bool
+	errorMessage string
+
+	// Current file transfer
+	transferPath  string
+	transferData  []byte
+	transferLen   int
+	transferIndex int
+}
+
+func isSimulator(name string) bool {
+	return strings.HasPrefix(name, "sim://")
+}
+
+// Open a simulated board. The board type and the wiring of the control
+// lines can be included in the port name, for example
+// sim://ESP32-THING?wiring=rts-inverted. The board is running when opened.
+func openSimTransport(name string) (*simTransport, error) {
+	model := strings.TrimPrefix(name, "sim://")
+	wiring := "auto"
+
+	if i := strings.Index(model, "?"); i >= 0 {
+		params, err := url.ParseQuery(model[i+1:])
+		if err != nil {
+			return nil, errors.New("invalid simulator parameters: " + err.Error())
+		}
+
+		if params.Get("wiring") != "" {
+			wiring = params.Get("wiring")
+		}
+
+		model = model[:i]
+	}
+
+	if model == "" {
+		model = "ESP32-CORE-BOARD"
+	}
+
+	if _, ok := simWirings[wiring]; !ok {
+		names := []string{}
+		for name := range simWirings {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		return nil, errors.New("unknown simulator wiring " + wiring + ", use one of " + strings.Join(names, ", "))
+	}
+
+	sim := &simTransport{
+		state:  simStateShell,
+		model:  model,
+		wiring: simWirings[wiring],
+		en:     true,
+		fs:     make(map[string]*simFile),
+	}
+
+	sim.cond = sync.NewCond(&sim.mu)
+
+	now := time.Now()
+
+	sim.fs["/"] = &simFile{dir: true, modTime: now}
+	sim.fs["/examples"] = &simFile{dir: true, modTime: now}
+	sim.fs["/system.lua"] = &simFile{content: []byte("-- this script is executed at boot\n"), modTime: now}
+	sim.fs["/examples/blink.lua"] = &simFile{content: []byte("pio.pin.setdir(pio.OUTPUT, pio.GPIO2)\n"), modTime: now}
+
+	return sim, nil
+}
+
+func (sim *simTransport) Read(b []byte) (int, error) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	for sim.out.Len() == 0 && !sim.closed {
+		sim.cond.Wait()
+	}
+
+	if sim.closed {
+		return 0, io.EOF
+	}
+
+	return sim.out.Read(b)
+}
+
+func (sim *simTransport) Write(b []byte) (int, error) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	if sim.closed {
+		return 0, errors.New("simulator is closed")
+	}
+
+	for _, c := range b {
+		sim.input(c)
+	}
+
+	return len(b), nil
+}
+
+func (sim *simTransport) Close() error {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.closed = true
+	sim.cond.Broadcast()
+
+	return nil
+}
+
+func (sim *simTransport) SetRTS(on bool) error {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.rts = on
+	sim.controlLines()
+
+	return nil
+}
+
+func (sim *simTransport) SetDTR(on bool) error {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.dtr = on
+	sim.controlLines()
+
+	return nil
+}
+
+// Update the EN pin after a change in the control lines. The board is off
+// while EN is low, and starts when EN goes high. IO0 is sampled a bit
+// later, and if it's low the board enters in download mode instead of
+// booting Lua RTOS. Must be called with the lock held.
+func (sim *simTransport) controlLines() {
+	en, io0 := sim.wiring(sim.rts, sim.dtr)
+
+	// IO0 is sampled from the time when the control lines change, not when
+	// the boot timer runs, that can be late
+	if time.Now().Before(sim.strapEnd) {
+		sim.io0 = io0
+	}
+
+	if en == sim.en {
+		return
+	}
+
+	sim.en = en
+	sim.boots = sim.boots + 1
+	sim.state = simStateOff
+
+	if !en {
+		return
+	}
+
+	sim.io0 = io0
+	sim.strapEnd = time.Now().Add(simStrappingDelay)
+
+	boots := sim.boots
+	go func() {
+		time.Sleep(simStrappingDelay)
+
+		sim.mu.Lock()
+		defer sim.mu.Unlock()
+
+		if sim.boots != boots {
+			return
+		}
+
+		if sim.io0 {
+			sim.boot()
+		} else {
+			sim.download()
+		}
+	}()
+}
+
+// Enter in download mode, as the ROM bootloader does when IO0 is low at
+// reset. Must be called with the lock held.
+func (sim *simTransport) download() {
+	sim.state = simStateDownload
+	sim.line = nil
+
+	sim.println("ets Jun  8 2016 00:22:57")
+	sim.println("")
+	sim.println("rst:0x1 (POWERON_RESET),boot:0x3 (DOWNLOAD_BOOT(UART0/UART1/SDIO_REI_REO_V2))")
+	sim.println("waiting for download")
+}
+
+func (sim *simTransport) HasControlLines() bool {
+	return true
+}
+
+// Send data to the console. Must be called with the lock held.
+func (sim *simTransport) print(s string) {
+	sim.out.WriteString(s)
+	sim.cond.Broadcast()
+}
+
+func (sim *simTransport) println(s string) {
+	sim.print(s + "\r\n")
+}
+
+func (sim *simTransport) prompt() {
+	sim.print("/ > ")
+}
+
+// Boot the board. Must be called with the lock held.
+func (sim *simTransport) boot() {
+	sim.boots = sim.boots + 1
+	sim.state = simStateBooting
+	sim.line = nil
+
+	sim.println("ets Jun  8 2016 00:22:57")
+	sim.println("")
+	sim.println("rst:0x1 (POWERON_RESET),boot:0x13 (SPI_FAST_FLASH_BOOT)")
+	sim.println("configsip: 0, SPIWP:0xee")
+	sim.println("mode:DIO, clock div:2")
+	sim.println("entry 0x40080400")
+	sim.println("Booting Lua RTOS...")
+
+	// Boot scripts are run if the console doesn't abort them
+	boots := sim.boots
+	go func() {
+		time.Sleep(time.Millisecond * 1000)
+
+		sim.mu.Lock()
+		defer sim.mu.Unlock()
+
+		if sim.boots == boots && sim.state == simStateBooting {
+			sim.banner()
+		}
+	}()
+}
+
+// Print the Lua RTOS banner, and enter in the shell. Must be called with
+// the lock held.
+func (sim *simTransport) banner() {
+	sim.state = simStateShell
+
+	sim.println("")
+	sim.println("  /\\       /\\")
+	sim.println(" /  \\_____/  \\")
+	sim.println("/_____________\\")
+	sim.println("W H I T E C A T")
+	sim.println("")
+	sim.println("Lua RTOS beta 0.1 build " + simBuild + " Copyright (C) 2015 - 2018 whitecatboard.org")
+	sim.println("board type " + sim.model)
+	sim.println("")
+	sim.prompt()
+}
+
+// Process a byte received from the console. Must be called with the lock
+// held.
+func (sim *simTransport) input(c byte) {
+	switch sim.state {
+	case simStateBooting:
+		// Ctrl-D aborts the boot scripts
+		if c == 4 {
+			sim.println("Lua RTOS-boot-scripts-aborted-ESP32")
+			sim.banner()
+		}
+
+	case simStateShell:
+		sim.shell(c)
+
+	case simStateReceiving:
+		sim.receiveByte(c)
+
+	case simStateSending:
+		// The console request a new chunk sending a C
+		if c == 'C' {
+			sim.sendChunk()
+		}
+	}
+}
+
+// Process a byte received in the shell. Must be called with the lock held.
+func (sim *simTransport) shell(c byte) {
+	lastCR := sim.lastCR
+	sim.lastCR = (c == '\r')
+
+	switch c {
+	case '\r', '\n':
+		// A \n just after a \r only ends the prompt line
+		if c == '\n' && lastCR {
+			sim.print("\r\n")
+			return
+		}
+
+		command := strings.TrimSpace(string(sim.line))
+		sim.line = nil
+
+		sim.print("\r\n")
+
+		if command != "" {
+			sim.execute(command)
+		}
+
+		if sim.state == simStateShell {
+			sim.prompt()
+		}
+
+	case 3:
+		// Ctrl-C
+		sim.line = nil
+		sim.print("^C\r\n")
+		sim.prompt()
+
+	case 8, 127:
+		// Backspace
+		if len(sim.line) > 0 {
+			sim.line = sim.line[:len(sim.line)-1]
+			sim.print("\b \b")
+		}
+
+	default:
+		if c >= 32 {
+			sim.line = append(sim.line, c)
+			sim.out.WriteByte(c)
+			sim.cond.Broadcast()
+		}
+	}
+}
+
+// Execute a command typed in the shell. Must be called with the lock held.
+func (sim *simTransport) execute(command string) {
+	sim.chunk = "stdin"
+	sim.chunkLine = 1
+	sim.repl = true
+
+	sim.run(command)
+}
+
+// Return values from the command in execution. As in the Lua shell, values
+// are only printed when the command was typed in the shell.
+func (sim *simTransport) returns(values ...string) {
+	sim.returned = values
+
+	if sim.asserted {
+		if len(values) > 0 && (values[0] == "nil" || values[0] == "false") {
+			message := "assertion failed!"
+			if len(values) > 1 {
+				message = values[1]
+			}
+
+			sim.fail(message)
+		}
+
+		return
+	}
+
+	if sim.repl {
+		sim.println(strings.Join(values, "\t"))
+	}
+}
+
+// Run code sent in a RPC call, printing the call markers. As in the board,
+// returning nil and an error message is a failure.
+func (sim *simTransport) rpc(sequence string, code string) {
+	sim.println("<wcc:begin:" + sequence + ">")
+
+	sim.chunk = "rpc"
+	sim.chunkLine = 1
+	sim.repl = false
+	sim.protected = true
+	sim.run(code)
+	sim.protected = false
+
+	if !sim.failed && len(sim.returned) > 1 && sim.returned[0] == "nil" {
+		sim.errorMessage = sim.returned[1]
+		sim.failed = true
+	}
+
+	if sim.failed {
+		sim.println("<wcc:end:" + sequence + ":1:" + sim.errorMessage + ">")
+	} else {
+		sim.println("<wcc:end:" + sequence + ":0:>")
+	}
+}
+
+// Run a command. Must be called with the lock held.
+func (sim *simTransport) run(command string) {
+	sim.failed = false
+	sim.returned = nil
+
+	for _, cmd := range simCommands {
+		if args := cmd.re.FindStringSubmatch(command); args != nil {
+			cmd.run(sim, args)
+			return
+		}
+	}
+
+	sim.error("command not supported by the simulator")
+}
+
+// Raise an error in the command in execution
+func (sim *simTransport) error(message string) {
+	sim.fail(sim.chunk + ":" + strconv.Itoa(sim.chunkLine) + ": " + message)
+}
+
+// Raise an error in the command in execution, without the position
+func (sim *simTransport) fail(message string) {
+	if sim.protected {
+		sim.errorMessage = message
+	} else {
+		sim.println(message)
+	}
+
+	sim.failed = true
+}
+
+// Evaluate a Lua expression. Only a few expressions are supported.
+func (sim *simTransport) evalExpression(code string) ([]interface{}, bool) {
+	code = strings.TrimSpace(code)
+
+	switch code {
+	case "os.board()":
+		return []interface{}{sim.model, sim.subtype, sim.brand}, true
+	case "os.version()":
+		build, _ := strconv.Atoi(simBuild)
+		return []interface{}{"Lua RTOS", "beta 0.1", build, simCommit}, true
+	case "nil":
+		return []interface{}{nil}, true
+	case "true", "false":
+		return []interface{}{code == "true"}, true
+	}
+
+	if s, err := luaUnquote(code); err == nil {
+		return []interface{}{s}, true
+	}
+
+	if n, err := strconv.ParseFloat(code, 64); err == nil {
+		return []interface{}{n}, true
+	}
+
+	return nil, false
+}
+
+// Run the wcc_eval function sent by the console, printing the result as a
+// JSON object
+func (sim *simTransport) evalJSON(quoted string) {
+	var result map[string]interface{}
+
+	code, err := luaUnquote(quoted)
+	if err != nil {
+		sim.error(err.Error())
+		return
+	}
+
+	if values, ok := sim.evalExpression(code); ok {
+		result = map[string]interface{}{"ok": true, "values": values}
+	} else {
+		protected := sim.protected
+
+		sim.chunk = "eval"
+		sim.protected = true
+		sim.run(code)
+		sim.protected = protected
+
+		if sim.failed {
+			result = map[string]interface{}{"ok": false, "error": sim.errorMessage}
+		} else {
+			result = map[string]interface{}{"ok": true, "values": []interface{}{}}
+		}
+
+		// wcc_eval catches the error
+		sim.failed = false
+	}
+
+	out, _ := json.Marshal(result)
+	sim.println(string(out))
+}
+
+// Run code sent by eval as the shell does, printing the values returned
+func (sim *simTransport) evalShell(code string) {
+	if values, ok := sim.evalExpression(code); ok {
+		var texts []string
+
+		for _, value := range values {
+			if value == nil {
+				texts = append(texts, "nil")
+			} else {
+				texts = append(texts, fmt.Sprint(value))
+			}
+		}
+
+		sim.println(strings.Join(texts, "\t"))
+		return
+	}
+
+	sim.runLines("stdin", code, true)
+}
+
+// Run a script. Each line of the script is run as a command, until a
+// command fails.
+func (sim *simTransport) dofile(p string) {
+	f, ok := sim.fs[sim.absPath(p)]
+	if !ok || f.dir {
+		sim.error("cannot open " + p)
+		return
+	}
+
+	sim.runLines(p, string(f.content), false)
+}
+
+// Run Lua code line by line, until a line fails. If repl is true, values
+// returned by each line are printed.
+func (sim *simTransport) runLines(chunk string, code string, repl bool) {
+	for n, line := range strings.Split(code, "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "--") {
+			continue
+		}
+
+		sim.chunk = chunk
+		sim.chunkLine = n + 1
+		sim.repl = repl
+
+		if sim.run(line); sim.failed {
+			return
+		}
+	}
+}
+
+func (sim *simTransport) remove(p string) {
+	name := sim.absPath(p)
+
+	f, ok := sim.fs[name]
+	if !ok || name == "/" {
+		sim.returns("nil", p+": No such file or directory", "2")
+		return
+	}
+
+	if f.dir && len(sim.dirEntries(name)) > 0 {
+		sim.returns("nil", p+": Directory not empty", "90")
+		return
+	}
+
+	delete(sim.fs, name)
+
+	sim.returns("true")
+}
+
+func (sim *simTransport) mkdir(p string) {
+	name := sim.absPath(p)
+
+	if _, ok := sim.fs[name]; ok {
+		sim.returns("nil", p+": File exists", "17")
+		return
+	}
+
+	if f, ok := sim.fs[path.Dir(name)]; !ok || !f.dir {
+		sim.returns("nil", p+": No such file or directory", "2")
+		return
+	}
+
+	sim.fs[name] = &simFile{dir: true, modTime: time.Now()}
+
+	sim.returns("true")
+}
+
+func (sim *simTransport) rename(src string, dst string) {
+	from := sim.absPath(src)
+	to := sim.absPath(dst)
+
+	f, ok := sim.fs[from]
+	if !ok || from == "/" {
+		sim.returns("nil", src+": No such file or directory", "2")
+		return
+	}
+
+	if parent, ok := sim.fs[path.Dir(to)]; !ok || !parent.dir {
+		sim.returns("nil", dst+": No such file or directory", "2")
+		return
+	}
+
+	// Move the directory content too
+	for name, child := range sim.fs {
+		if strings.HasPrefix(name, from+"/") {
+			delete(sim.fs, name)
+			sim.fs[to+strings.TrimPrefix(name, from)] = child
+		}
+	}
+
+	delete(sim.fs, from)
+	sim.fs[to] = f
+
+	sim.returns("true")
+}
+
+// Create an empty file if it doesn't exist
+func (sim *simTransport) touch(p string) {
+	name := sim.absPath(p)
+
+	if f, ok := sim.fs[name]; ok {
+		if f.dir {
+			sim.fail(p + ": Is a directory")
+		}
+
+		return
+	}
+
+	if parent, ok := sim.fs[path.Dir(name)]; !ok || !parent.dir {
+		sim.fail(p + ": No such file or directory")
+		return
+	}
+
+	sim.fs[name] = &simFile{modTime: time.Now()}
+}
+
+// Copy a file
+func (sim *simTransport) copy(src string, dst string) {
+	f, ok := sim.fs[sim.absPath(src)]
+	if !ok || f.dir {
+		sim.fail(src + ": No such file or directory")
+		return
+	}
+
+	name := sim.absPath(dst)
+
+	if parent, ok := sim.fs[path.Dir(name)]; !ok || !parent.dir {
+		sim.fail(dst + ": No such file or directory")
+		return
+	}
+
+	if to, ok := sim.fs[name]; ok && to.dir {
+		sim.fail(dst + ": Is a directory")
+		return
+	}
+
+	sim.fs[name] = &simFile{content: append([]byte{}, f.content...), modTime: time.Now()}
+}
+
+// Get the absolute path for a path in the simulator file system
+func (sim *simTransport) absPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+
+	return path.Clean(p)
+}
+
+// Get the entries of a directory, sorted by name
+func (sim *simTransport) dirEntries(dir string) []string {
+	entries := []string{}
+
+	for name := range sim.fs {
+		if name != "/" && path.Dir(name) == dir {
+			entries = append(entries, name)
+		}
+	}
+
+	sort.Strings(entries)
+
+	return entries
+}
+
+func (sim *simTransport) ls(p string) {
+	dir := sim.absPath(p)
+
+	if f, ok := sim.fs[dir]; !ok || !f.dir {
+		sim.returns("nil", p+": No such file or directory", "2")
+		return
+	}
+
+	for _, name := range sim.dirEntries(dir) {
+		f := sim.fs[name]
+
+		if f.dir {
+			sim.println("d\t-\t" + f.modTime.Format(lsDateLayout) + "\t" + path.Base(name))
+		} else {
+			sim.println("f\t" + strconv.Itoa(len(f.content)) + "\t" + f.modTime.Format(lsDateLayout) + "\t" + path.Base(name))
+		}
+	}
+}
+
+// Print the Adler-32 checksum of a file
+func (sim *simTransport) checksum(p string) {
+	f, ok := sim.fs[sim.absPath(p)]
+	if !ok || f.dir {
+		sim.error(p + ": No such file or directory")
+		return
+	}
+
+	sim.println(fmt.Sprintf("%08x", adler32.Checksum(f.content)))
+}
+
+// Start the reception of a file sent by the console
+func (sim *simTransport) receive(p string) {
+	p = sim.absPath(p)
+
+	if f, ok := sim.fs[path.Dir(p)]; !ok || !f.dir {
+		sim.error(p + ": No such file or directory")
+		return
+	}
+
+	sim.state = simStateReceiving
+	sim.transferPath = p
+	sim.transferData = nil
+	sim.transferLen = -1
+
+	sim.println("C")
+}
+
+// Process a byte of a file sent by the console
+func (sim *simTransport) receiveByte(c byte) {
+	if sim.transferLen < 0 {
+		// Chunk length
+		sim.transferLen = int(c)
+
+		if sim.transferLen == 0 {
+			sim.fs[sim.transferPath] = &simFile{content: sim.transferData, modTime: time.Now()}
+			sim.state = simStateShell
+
+			sim.returns("true")
+			sim.prompt()
+		}
+
+		return
+	}
+
+	sim.transferData = append(sim.transferData, c)
+	sim.transferLen = sim.transferLen - 1
+
+	if sim.transferLen == 0 {
+		sim.transferLen = -1
+		sim.println("C")
+	}
+}
+
+// Start sending a file to the console
+func (sim *simTransport) send(p string) {
+	f, ok := sim.fs[sim.absPath(p)]
+	if !ok || f.dir {
+		sim.error(p + ": No such file or directory")
+		return
+	}
+
+	sim.state = simStateSending
+	sim.transferData = f.content
+	sim.transferIndex = 0
+}
+
+// Send next chunk of the file sent to the console
+func (sim *simTransport) sendChunk() {
+	n := len(sim.transferData) - sim.transferIndex
+	if n > 255 {
+		n = 255
+	}
+
+	sim.out.WriteByte(byte(n))
+	sim.out.Write(sim.transferData[sim.transferIndex : sim.transferIndex+n])
+	sim.cond.Broadcast()
+
+	sim.transferIndex = sim.transferIndex + n
+
+	if n == 0 {
+		sim.state = simStateShell
+
+		sim.returns("true")
+		sim.prompt()
+	}
+}
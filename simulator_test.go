@@ -0,0 +1,117 @@
+/*
+ * Whitecat Console, board simulator tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Get the state of the simulator, after the pending boot timers are run
+func simState(sim *simTransport) int {
+	time.Sleep(simStrappingDelay * 4)
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	return sim.state
+}
+
+func TestSimulatorReset(t *testing.T) {
+	// Sequence used by esptool for enter in the ROM bootloader
+	bootloader := []resetStep{setDTR(false), setRTS(true), waitPulse, setDTR(true), setRTS(false), waitPulse, setDTR(false)}
+
+	tests := []struct {
+		wiring string
+		steps  []resetStep
+		state  int
+	}{
+		{"auto", resetSequences["rts"], simStateBooting},
+		{"auto", resetSequences["none"], simStateShell},
+		{"auto", resetSequences["rts-inverted"], simStateOff},
+		{"auto", resetSequences["dtr"], simStateShell},
+		{"auto", bootloader, simStateDownload},
+		{"rts", resetSequences["rts"], simStateBooting},
+		{"rts-inverted", resetSequences["rts-inverted"], simStateBooting},
+		{"dtr", resetSequences["dtr"], simStateBooting},
+		{"dtr-inverted", resetSequences["dtr-inverted"], simStateBooting},
+		{"dtr-inverted", resetSequences["dtr"], simStateOff},
+	}
+
+	for i, test := range tests {
+		sim, err := openSimTransport("sim://?wiring=" + test.wiring)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, step := range test.steps {
+			step(sim, time.Millisecond*10)
+		}
+
+		if state := simState(sim); state != test.state {
+			t.Errorf("%d (%s): state is %d, expected %d", i, test.wiring, state, test.state)
+		}
+
+		sim.Close()
+	}
+}
+
+func TestSimulatorPortName(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		ok    bool
+	}{
+		{"sim://", "ESP32-CORE-BOARD", true},
+		{"sim://ESP32-THING", "ESP32-THING", true},
+		{"sim://ESP32-THING?wiring=dtr", "ESP32-THING", true},
+		{"sim://?wiring=rts-inverted", "ESP32-CORE-BOARD", true},
+		{"sim://?wiring=usb", "", false},
+		{"sim://?wiring=%zz", "", false},
+	}
+
+	for _, test := range tests {
+		sim, err := openSimTransport(test.name)
+
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: failed: %v", test.name, err)
+		} else if sim.model != test.model {
+			t.Errorf("%s: model is %s, expected %s", test.name, sim.model, test.model)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+/*
+ * Whitecat Console, folder synchronization
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/adler32"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the file with the paths excluded from the synchronization
+const ignoreFileName = ".wccignore"
+
+// Lua snippet that defines the wcc_checksum function, that prints the
+// Adler-32 checksum of a file in hexadecimal. The snippet is sent in one
+// line, so it can't contain comments.
+const luaChecksum = `
+local function wcc_checksum(p)
+	local f = assert(io.open(p, "rb"))
+	local a, b = 1, 0
+	while true do
+		local s = f:read(256)
+		if not s then
+			break
+		end
+		for i = 1, #s do
+			a = (a + s:byte(i)) % 65521
+			b = (b + a) % 65521
+		end
+	end
+	f:close()
+	print(string.format("%08x", (b << 16) | a))
+end
+`
+
+// Get the checksum of a file in the board, of size bytes
+func (board *Board) checksum(name string, size int64) (string, error) {
+	return board.callTimeout(luaOneLine(luaChecksum)+" wcc_checksum("+luaQuote(name)+")", fileTimeout(size))
+}
+
+// Patterns of the paths excluded from the synchronization, read from the
+// .wccignore file. Each line is a pattern that is matched against the path
+// relative to the folder, and against the file name. Patterns ended with /
+// only match directories. Lines starting with # are comments.
+type ignoreList []string
+
+func readIgnoreFile(file string) (ignoreList, error) {
+	list := ignoreList{ignoreFileName}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return list, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line != "" && !strings.HasPrefix(line, "#") {
+			list = append(list, line)
+		}
+	}
+
+	return list, scanner.Err()
+}
+
+// Path is excluded? rel is the path relative to the folder, using /
+func (list ignoreList) match(rel string, dir bool) bool {
+	for _, pattern := range list {
+		if strings.HasSuffix(pattern, "/") {
+			if !dir {
+				continue
+			}
+
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+
+		pattern = strings.TrimPrefix(pattern, "/")
+
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Synchronization options
+type syncOptions struct {
+	// Compare files using a checksum computed in the board, instead of the
+	// size and date
+	checksum bool
+
+	// Remove the files in the board that don't exist in the folder
+	delete bool
+
+	// Only show the changes
+	dryRun bool
+}
+
+// Synchronization result
+type syncResult struct {
+	uploaded  int
+	bytes     int
+	unchanged int
+	deleted   int
+}
+
+// Year before the dates in the board are valid. Boards without the clock
+// set have dates in 1970.
+const minBoardYear = 2000
+
+// File of the folder is changed from the file in the board?
+func (board *Board) changed(name string, info os.FileInfo, remote string, entry fileEntry, options syncOptions) (bool, error) {
+	if entry.Size != info.Size() {
+		return true, nil
+	}
+
+	// Dates in the board have a resolution of 1 minute. If the board clock
+	// is not set the dates can't be compared, so the checksum is used.
+	modTime := entry.modTime()
+
+	if options.checksum || modTime.Year() < minBoardYear {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return false, err
+		}
+
+		sum, err := board.checksum(remote, entry.Size)
+		if err != nil {
+			return false, err
+		}
+
+		return fmt.Sprintf("%08x", adler32.Checksum(content)) != strings.TrimSpace(sum), nil
+	}
+
+	return info.ModTime().Truncate(time.Minute).After(modTime), nil
+}
+
+// Error for a path that is a file in the board and a directory in the
+// folder, or the opposite
+func syncConflict(target string, entry fileEntry) error {
+	kind, other := "file", "directory"
+	if entry.isDir() {
+		kind, other = other, kind
+	}
+
+	return fmt.Errorf("%s is a %s in the board, but a %s in the folder, use --delete for replace it", target, kind, other)
+}
+
+// Synchronize a directory in the board with a folder of the computer. Only
+// the new and changed files are uploaded.
+func (board *Board) syncDir(src string, dst string, options syncOptions) (syncResult, error) {
+	var result syncResult
+
+	dst = path.Clean("/" + dst)
+
+	ignore, err := readIgnoreFile(filepath.Join(src, ignoreFileName))
+	if err != nil {
+		return result, err
+	}
+
+	// Files and directories in the folder, by relative path
+	local := map[string]os.FileInfo{}
+	var names []string
+
+	err = filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, name)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		if ignore.match(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		local[rel] = info
+		names = append(names, rel)
+
+		return nil
+	})
+
+	if err != nil {
+		return result, err
+	}
+
+	// Files and directories in the board, by relative path
+	known := map[string]bool{}
+	remote := map[string]fileEntry{}
+	var remoteNames []string
+
+	if !options.dryRun {
+		if err := board.makeDirs(dst, known); err != nil {
+			return result, err
+		}
+	}
+
+	err = board.walk(dst, func(name string, entry fileEntry) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(name, dst), "/")
+
+		remote[rel] = entry
+		remoteNames = append(remoteNames, rel)
+
+		if entry.isDir() {
+			known[name] = true
+		}
+
+		return nil
+	})
+
+	if err != nil && !options.dryRun {
+		return result, err
+	}
+
+	// Remove the files that don't exist in the folder, and the files that
+	// are a directory in the folder or the opposite, before the upload.
+	// Paths are removed in reverse order, so the files of a directory are
+	// removed before it.
+	if options.delete {
+		sort.Sort(sort.Reverse(sort.StringSlice(remoteNames)))
+
+		// Directories with files that are not removed
+		kept := map[string]bool{}
+
+		for _, rel := range remoteNames {
+			entry := remote[rel]
+
+			info, exists := local[rel]
+
+			if (exists && info.IsDir() == entry.isDir()) || kept[rel] || ignored(ignore, rel, entry.isDir()) {
+				for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+					kept[dir] = true
+				}
+
+				continue
+			}
+
+			notify("progress", "\033[Kremoving "+path.Join(dst, rel)+"\r\n")
+
+			if !options.dryRun {
+				if err := board.remove(path.Join(dst, rel)); err != nil {
+					return result, err
+				}
+			}
+
+			delete(remote, rel)
+
+			result.deleted = result.deleted + 1
+		}
+	}
+
+	// Upload the new and changed files
+	for _, rel := range names {
+		info := local[rel]
+		name := filepath.Join(src, filepath.FromSlash(rel))
+		target := path.Join(dst, rel)
+
+		entry, exists := remote[rel]
+
+		if exists && entry.isDir() != info.IsDir() {
+			return result, syncConflict(target, entry)
+		}
+
+		if info.IsDir() {
+			if exists && entry.isDir() {
+				continue
+			}
+
+			if options.dryRun {
+				notify("progress", "\033[Kcreating "+target+"\r\n")
+			} else if err := board.makeDirs(target, known); err != nil {
+				return result, err
+			}
+
+			continue
+		}
+
+		if exists {
+			changed, err := board.changed(name, info, target, entry, options)
+			if err != nil {
+				return result, err
+			}
+
+			if !changed {
+				result.unchanged = result.unchanged + 1
+				continue
+			}
+		}
+
+		notify("progress", "\033[Kuploading "+name+" -> "+target+"\r\n")
+
+		if !options.dryRun {
+			content, err := ioutil.ReadFile(name)
+			if err != nil {
+				return result, err
+			}
+
+			if err := board.makeDirs(path.Dir(target), known); err != nil {
+				return result, err
+			}
+
+			if err := board.writeFile(target, content); err != nil {
+				return result, err
+			}
+		}
+
+		result.uploaded = result.uploaded + 1
+		result.bytes = result.bytes + int(info.Size())
+	}
+
+	return result, nil
+}
+
+// Path, or one of its parent directories, is excluded?
+func ignored(ignore ignoreList, rel string, dir bool) bool {
+	for rel != "." && rel != "/" && rel != "" {
+		if ignore.match(rel, dir) {
+			return true
+		}
+
+		rel = path.Dir(rel)
+		dir = true
+	}
+
+	return false
+}
+
+// Summary of the synchronization
+func (result syncResult) String() string {
+	return strconv.Itoa(result.uploaded) + " files uploaded, " + strconv.Itoa(result.bytes) + " bytes, " +
+		strconv.Itoa(result.unchanged) + " unchanged, " + strconv.Itoa(result.deleted) + " removed"
+}
@@ -0,0 +1,256 @@
+/*
+ * Whitecat Console, folder synchronization tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestIgnoreList(t *testing.T) {
+	list := ignoreList{ignoreFileName, "*.tmp", "build/", "/docs/notes.txt"}
+
+	tests := []struct {
+		rel   string
+		dir   bool
+		match bool
+	}{
+		{".wccignore", false, true},
+		{"cache.tmp", false, true},
+		{"lib/cache.tmp", false, true},
+		{"build", true, true},
+		{"build", false, false},
+		{"lib/build", true, true},
+		{"docs/notes.txt", false, true},
+		{"main.lua", false, false},
+		{"lib", true, false},
+	}
+
+	for _, test := range tests {
+		if match := list.match(test.rel, test.dir); match != test.match {
+			t.Errorf("%s: match is %v, expected %v", test.rel, match, test.match)
+		}
+	}
+
+	if !ignored(list, "build/out/main.lua", false) {
+		t.Error("file of an ignored directory not ignored")
+	}
+}
+
+// Create the files of a local folder, with a modification time in the past
+func writeSyncFolder(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(name, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// Files and directories of the simulated board, directories end with /
+func simFiles(sim *simTransport) []string {
+	var names []string
+
+	for name, f := range sim.fs {
+		if f.dir {
+			name = name + "/"
+		}
+
+		if name != "//" {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func TestSyncDir(t *testing.T) {
+	system := "-- this script is executed at boot\n"
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		board   map[string]string
+		clock   bool
+		options syncOptions
+		result  syncResult
+		fs      []string
+		ok      bool
+	}{
+		{
+			"upload",
+			map[string]string{"system.lua": system, "main.lua": "main()\n", "lib/util.lua": "util()\n"},
+			nil,
+			true,
+			syncOptions{},
+			syncResult{uploaded: 2, bytes: 14, unchanged: 1},
+			[]string{"/examples/", "/examples/blink.lua", "/lib/", "/lib/util.lua", "/main.lua", "/system.lua"},
+			true,
+		},
+		{
+			"delete",
+			map[string]string{"system.lua": system, ".wccignore": "*.tmp\nbuild/\n", "build/out.bin": "x"},
+			map[string]string{"/cache.tmp": "cache", "/lib/old.lua": "old()\n"},
+			true,
+			syncOptions{delete: true},
+			syncResult{unchanged: 1, deleted: 4},
+			[]string{"/cache.tmp", "/system.lua"},
+			true,
+		},
+		{
+			"delete ignored directory",
+			map[string]string{"system.lua": system, ".wccignore": "examples/\n"},
+			nil,
+			true,
+			syncOptions{delete: true},
+			syncResult{unchanged: 1},
+			[]string{"/examples/", "/examples/blink.lua", "/system.lua"},
+			true,
+		},
+		{
+			"dry run",
+			map[string]string{"main.lua": "main()\n"},
+			nil,
+			true,
+			syncOptions{delete: true, dryRun: true},
+			syncResult{uploaded: 1, bytes: 7, deleted: 3},
+			[]string{"/examples/", "/examples/blink.lua", "/system.lua"},
+			true,
+		},
+		{
+			"changed without clock",
+			map[string]string{"system.lua": "-- this script is executed at BOOT\n"},
+			nil,
+			false,
+			syncOptions{},
+			syncResult{uploaded: 1, bytes: 35},
+			[]string{"/examples/", "/examples/blink.lua", "/system.lua"},
+			true,
+		},
+		{
+			"unchanged without clock",
+			map[string]string{"system.lua": system},
+			nil,
+			false,
+			syncOptions{},
+			syncResult{unchanged: 1},
+			[]string{"/examples/", "/examples/blink.lua", "/system.lua"},
+			true,
+		},
+		{
+			"file replaces directory",
+			map[string]string{"examples": "file\n"},
+			nil,
+			true,
+			syncOptions{delete: true},
+			syncResult{uploaded: 1, bytes: 5, deleted: 3},
+			[]string{"/examples"},
+			true,
+		},
+		{
+			"directory replaces file",
+			map[string]string{"system.lua/init.lua": "init()\n"},
+			nil,
+			true,
+			syncOptions{delete: true},
+			syncResult{uploaded: 1, bytes: 7, deleted: 3},
+			[]string{"/system.lua/", "/system.lua/init.lua"},
+			true,
+		},
+		{
+			"file and directory conflict",
+			map[string]string{"examples": "file\n"},
+			nil,
+			true,
+			syncOptions{},
+			syncResult{},
+			[]string{"/examples/", "/examples/blink.lua", "/system.lua"},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		src := writeSyncFolder(t, test.files)
+
+		board := openSimBoard(t)
+		sim := board.port.(*simTransport)
+
+		for name, content := range test.board {
+			if err := board.makeDirs(filepath.ToSlash(filepath.Dir(name)), map[string]bool{}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := board.writeFile(name, []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !test.clock {
+			for _, f := range sim.fs {
+				f.modTime = time.Unix(0, 0)
+			}
+		}
+
+		result, err := board.syncDir(src, "/", test.options)
+		if test.ok != (err == nil) {
+			t.Errorf("%s: error is %v", test.name, err)
+			continue
+		}
+
+		if test.ok && result != test.result {
+			t.Errorf("%s: result is %+v, expected %+v", test.name, result, test.result)
+		}
+
+		if fs := simFiles(sim); !reflect.DeepEqual(fs, test.fs) {
+			t.Errorf("%s: board files are %v, expected %v", test.name, fs, test.fs)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * Whitecat Console, telnet protocol
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+// Telnet commands
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+// Telnet options
+const (
+	telnetOptBinary  = 0
+	telnetOptEcho    = 1
+	telnetOptSGA     = 3
+	telnetOptComPort = 44
+)
+
+// Telnet decoder states
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBIAC
+)
+
+// A minimal telnet protocol implementation, enough for talk with the
+// Lua RTOS telnet server. Commands embedded in the received data are
+// removed, and option negotiations are answered using the reply function.
+type telnet struct {
+	state int
+	cmd   byte
+	sb    []byte
+
+	// Options that we accept to enable on our side (DO) and on the
+	// remote side (WILL)
+	local  map[byte]bool
+	remote map[byte]bool
+
+	// Options currently enabled on our side and on the remote side
+	localEnabled  map[byte]bool
+	remoteEnabled map[byte]bool
+
+	// Send raw bytes to the remote side
+	reply func(b []byte) error
+
+	// If not nil, called when a subnegotiation is received
+	subnegotiation func(option byte, data []byte)
+}
+
+func newTelnet(reply func(b []byte) error) *telnet {
+	return &telnet{
+		state: telnetStateData,
+		local: map[byte]bool{
+			telnetOptBinary: true,
+			telnetOptSGA:    true,
+		},
+		remote: map[byte]bool{
+			telnetOptBinary: true,
+			telnetOptEcho:   true,
+			telnetOptSGA:    true,
+		},
+		localEnabled:  map[byte]bool{},
+		remoteEnabled: map[byte]bool{},
+		reply:         reply,
+	}
+}
+
+// Request to enable an option on our side
+func (t *telnet) will(option byte) error {
+	t.local[option] = true
+	t.localEnabled[option] = true
+
+	return t.reply([]byte{telnetIAC, telnetWILL, option})
+}
+
+// Request to enable an option on the remote side
+func (t *telnet) do(option byte) error {
+	t.remote[option] = true
+	t.remoteEnabled[option] = true
+
+	return t.reply([]byte{telnetIAC, telnetDO, option})
+}
+
+// Send a subnegotiation for an option
+func (t *telnet) sendSubnegotiation(option byte, data []byte) error {
+	b := []byte{telnetIAC, telnetSB, option}
+	b = append(b, t.encode(data)...)
+	b = append(b, telnetIAC, telnetSE)
+
+	return t.reply(b)
+}
+
+// Decode received data, removing telnet commands. Decoded data is stored
+// in dst, that must have at least the same length than src.
+func (t *telnet) decode(dst []byte, src []byte) int {
+	n := 0
+
+	for _, c := range src {
+		switch t.state {
+		case telnetStateData:
+			if c == telnetIAC {
+				t.state = telnetStateIAC
+			} else {
+				dst[n] = c
+				n = n + 1
+			}
+
+		case telnetStateIAC:
+			switch c {
+			case telnetIAC:
+				dst[n] = c
+				n = n + 1
+				t.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				t.cmd = c
+				t.state = telnetStateOption
+			case telnetSB:
+				t.sb = t.sb[:0]
+				t.state = telnetStateSB
+			default:
+				t.state = telnetStateData
+			}
+
+		case telnetStateOption:
+			t.negotiate(t.cmd, c)
+			t.state = telnetStateData
+
+		case telnetStateSB:
+			if c == telnetIAC {
+				t.state = telnetStateSBIAC
+			} else {
+				t.sb = append(t.sb, c)
+			}
+
+		case telnetStateSBIAC:
+			if c == telnetSE {
+				if t.subnegotiation != nil && len(t.sb) > 0 {
+					t.subnegotiation(t.sb[0], t.sb[1:])
+				}
+
+				t.state = telnetStateData
+			} else {
+				t.sb = append(t.sb, c)
+				t.state = telnetStateSB
+			}
+		}
+	}
+
+	return n
+}
+
+// Encode data to send, escaping the IAC char
+func (t *telnet) encode(src []byte) []byte {
+	dst := make([]byte, 0, len(src))
+
+	for _, c := range src {
+		if c == telnetIAC {
+			dst = append(dst, telnetIAC)
+		}
+
+		dst = append(dst, c)
+	}
+
+	return dst
+}
+
+// Answer to an option negotiation. Only changes in the option state are
+// answered, for avoid negotiation loops.
+func (t *telnet) negotiate(cmd byte, option byte) {
+	switch cmd {
+	case telnetDO:
+		if !t.local[option] {
+			t.reply([]byte{telnetIAC, telnetWONT, option})
+		} else if !t.localEnabled[option] {
+			t.localEnabled[option] = true
+			t.reply([]byte{telnetIAC, telnetWILL, option})
+		}
+	case telnetDONT:
+		if t.localEnabled[option] {
+			t.localEnabled[option] = false
+			t.reply([]byte{telnetIAC, telnetWONT, option})
+		}
+	case telnetWILL:
+		if !t.remote[option] {
+			t.reply([]byte{telnetIAC, telnetDONT, option})
+		} else if !t.remoteEnabled[option] {
+			t.remoteEnabled[option] = true
+			t.reply([]byte{telnetIAC, telnetDO, option})
+		}
+	case telnetWONT:
+		if t.remoteEnabled[option] {
+			t.remoteEnabled[option] = false
+			t.reply([]byte{telnetIAC, telnetDONT, option})
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Whitecat Console, telnet protocol tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTelnetDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		input [][]byte
+		data  []byte
+		sb    []byte
+	}{
+		{"data", [][]byte{[]byte("hello\r\n")}, []byte("hello\r\n"), nil},
+		{"escaped IAC", [][]byte{{'a', telnetIAC, telnetIAC, 'b'}}, []byte{'a', telnetIAC, 'b'}, nil},
+		{"negotiation", [][]byte{{'a', telnetIAC, telnetWILL, telnetOptEcho, 'b'}}, []byte("ab"), nil},
+		{"split negotiation", [][]byte{{'a', telnetIAC}, {telnetDO}, {telnetOptSGA, 'b'}}, []byte("ab"), nil},
+		{"unknown command", [][]byte{{'a', telnetIAC, 241, 'b'}}, []byte("ab"), nil},
+		{"subnegotiation", [][]byte{{'a', telnetIAC, telnetSB, telnetOptComPort, 101, 1, telnetIAC, telnetSE, 'b'}}, []byte("ab"), []byte{telnetOptComPort, 101, 1}},
+		{"escaped IAC in subnegotiation", [][]byte{{telnetIAC, telnetSB, telnetOptComPort, telnetIAC, telnetIAC, telnetIAC, telnetSE}}, []byte{}, []byte{telnetOptComPort, telnetIAC}},
+	}
+
+	for _, test := range tests {
+		var sb []byte
+
+		tn := newTelnet(func(b []byte) error { return nil })
+		tn.subnegotiation = func(option byte, data []byte) {
+			sb = append([]byte{option}, data...)
+		}
+
+		data := []byte{}
+		for _, src := range test.input {
+			dst := make([]byte, len(src))
+			data = append(data, dst[:tn.decode(dst, src)]...)
+		}
+
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("%s: data is %v, expected %v", test.name, data, test.data)
+		}
+
+		if !bytes.Equal(sb, test.sb) {
+			t.Errorf("%s: subnegotiation is %v, expected %v", test.name, sb, test.sb)
+		}
+	}
+}
+
+func TestTelnetEncode(t *testing.T) {
+	tn := newTelnet(func(b []byte) error { return nil })
+
+	encoded := tn.encode([]byte{'a', telnetIAC, 'b'})
+	if expected := []byte{'a', telnetIAC, telnetIAC, 'b'}; !bytes.Equal(encoded, expected) {
+		t.Errorf("encoded is %v, expected %v", encoded, expected)
+	}
+}
+
+func TestTelnetNegotiate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmds    [][2]byte
+		replies []byte
+	}{
+		{"accept DO", [][2]byte{{telnetDO, telnetOptSGA}}, []byte{telnetIAC, telnetWILL, telnetOptSGA}},
+		{"refuse DO", [][2]byte{{telnetDO, telnetOptComPort}}, []byte{telnetIAC, telnetWONT, telnetOptComPort}},
+		{"accept WILL", [][2]byte{{telnetWILL, telnetOptEcho}}, []byte{telnetIAC, telnetDO, telnetOptEcho}},
+		{"refuse WILL", [][2]byte{{telnetWILL, telnetOptComPort}}, []byte{telnetIAC, telnetDONT, telnetOptComPort}},
+		{"repeated DO", [][2]byte{{telnetDO, telnetOptBinary}, {telnetDO, telnetOptBinary}}, []byte{telnetIAC, telnetWILL, telnetOptBinary}},
+		{"repeated WILL", [][2]byte{{telnetWILL, telnetOptEcho}, {telnetWILL, telnetOptEcho}}, []byte{telnetIAC, telnetDO, telnetOptEcho}},
+		{"DONT not enabled", [][2]byte{{telnetDONT, telnetOptSGA}}, []byte{}},
+		{"WONT not enabled", [][2]byte{{telnetWONT, telnetOptEcho}}, []byte{}},
+		{"DO and DONT", [][2]byte{{telnetDO, telnetOptSGA}, {telnetDONT, telnetOptSGA}}, []byte{telnetIAC, telnetWILL, telnetOptSGA, telnetIAC, telnetWONT, telnetOptSGA}},
+		{"WILL and WONT", [][2]byte{{telnetWILL, telnetOptEcho}, {telnetWONT, telnetOptEcho}}, []byte{telnetIAC, telnetDO, telnetOptEcho, telnetIAC, telnetDONT, telnetOptEcho}},
+	}
+
+	for _, test := range tests {
+		replies := []byte{}
+
+		tn := newTelnet(func(b []byte) error {
+			replies = append(replies, b...)
+			return nil
+		})
+
+		for _, cmd := range test.cmds {
+			tn.negotiate(cmd[0], cmd[1])
+		}
+
+		if !bytes.Equal(replies, test.replies) {
+			t.Errorf("%s: replies are %v, expected %v", test.name, replies, test.replies)
+		}
+	}
+}
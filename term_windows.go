@@ -0,0 +1,73 @@
+/*
+ * Whitecat Console, terminal raw mode for Windows
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+var (
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+// Console input modes
+const (
+	enableProcessedInput = 0x0001
+	enableLineInput      = 0x0002
+	enableEchoInput      = 0x0004
+)
+
+// Put the console connected to a file in raw mode, so each key is read
+// as soon it is pressed, without echo. Returns a function that restores
+// the console to the previous state.
+func makeRaw(f *os.File) (func(), error) {
+	var old uint32
+
+	fd := f.Fd()
+
+	if r, _, err := procGetConsoleMode.Call(fd, uintptr(unsafe.Pointer(&old))); r == 0 {
+		return nil, err
+	}
+
+	raw := old &^ (enableProcessedInput | enableLineInput | enableEchoInput)
+
+	if r, _, err := procSetConsoleMode.Call(fd, uintptr(raw)); r == 0 {
+		return nil, err
+	}
+
+	return func() {
+		procSetConsoleMode.Call(fd, uintptr(old))
+	}, nil
+}
@@ -0,0 +1,150 @@
+/*
+ * Whitecat Console, terminal mode
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Key for exit from terminal mode (Ctrl-])
+const terminalEscape = 0x1d
+
+// Colors used for highlight the board events
+const (
+	terminalColorError   = "\033[1;31m"
+	terminalColorWarning = "\033[1;33m"
+	terminalColorReset   = "\033[0m"
+)
+
+// Current line in terminal output
+var terminalLine []byte
+
+// Highlights the lines received from the board
+type highlighter struct {
+	// Last line was a Lua runtime error, or part of its stack traceback
+	inError bool
+}
+
+var terminalHighlight highlighter
+
+// Get the color used for highlight a line received from the board, or an
+// empty string if the line is not a Lua runtime error, its stack traceback,
+// or a warning
+func (h *highlighter) color(line string) string {
+	notification, _ := parseRuntimeMessage(line)
+
+	if notification == "boardRuntimeError" {
+		h.inError = true
+		return terminalColorError
+	} else if notification == "boardRuntimeWarning" {
+		h.inError = false
+		return terminalColorWarning
+	}
+
+	if h.inError && (tracebackStartRe.MatchString(line) || strings.HasPrefix(line, "\t")) {
+		return terminalColorError
+	}
+
+	h.inError = false
+
+	return ""
+}
+
+// Write a char received from the board in the terminal output. Lines with
+// a Lua runtime error or warning are highlighted when completed.
+func terminalOutput(c byte) {
+	if c == '\n' {
+		if color := terminalHighlight.color(string(terminalLine)); color != "" {
+			os.Stdout.Write([]byte("\r\033[K" + color + string(terminalLine) + terminalColorReset))
+		}
+
+		terminalLine = terminalLine[:0]
+	} else if c != '\r' {
+		terminalLine = append(terminalLine, c)
+	}
+
+	os.Stdout.Write([]byte{c})
+}
+
+// Run the interactive terminal. Keys pressed are sent to the board, and
+// the board's output is written to stdout until the escape key is pressed.
+func terminal() {
+	fmt.Print("Terminal mode, press Ctrl-] to exit\r\n\r\n")
+
+	// Put stdin in raw mode, if it is a terminal
+	restore, err := makeRaw(os.Stdin)
+	if err == nil {
+		defer restore()
+	} else {
+		log.Println("stdin is not a terminal:", err)
+	}
+
+	connectedBoard.consoleOut = true
+	connectedBoard.consoleIn = false
+
+	setConsoleMode(consoleModeTerminal)
+
+	defer setConsoleMode(consoleModeNone)
+
+	// Reconnect the board if it's detached
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go keepConnected(stop, func(status string) {
+		fmt.Print("\r\n" + terminalColorWarning + "--- " + status + terminalColorReset + "\r\n")
+	})
+
+	// Send a new line, so board sends the prompt. From now on, the board
+	// can be changed by the reconnect goroutine.
+	currentBoard().port.Write([]byte("\r"))
+
+	buffer := make([]byte, 64)
+
+	for {
+		n, err := os.Stdin.Read(buffer)
+		if err != nil {
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			if buffer[i] == terminalEscape {
+				currentBoard().port.Write(buffer[:i])
+				fmt.Print("\r\n")
+
+				return
+			}
+		}
+
+		currentBoard().port.Write(buffer[:n])
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Whitecat Console, Lua traceback parsing
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A frame of a Lua stack traceback
+type tracebackFrame struct {
+	Source   string `json:"source"`
+	Line     int    `json:"line,omitempty"`
+	Function string `json:"function"`
+}
+
+var (
+	tracebackStartRe    = regexp.MustCompile(`^\s*stack traceback:\s*$`)
+	tracebackFrameRe    = regexp.MustCompile(`^\t\s*(.+?):(?:(\d+):)? in (.*)$`)
+	tracebackFunctionRe = regexp.MustCompile(`^(?:function|local|global|field|method|upvalue) '(.*)'$`)
+)
+
+// Collects a Lua runtime error and the stack traceback that follows it
+type tracebackParser struct {
+	// Pending runtime error notification info, empty if there is not a
+	// runtime error in progress
+	info string
+
+	// In stack traceback
+	inTraceback bool
+
+	frames []tracebackFrame
+}
+
+// Start a runtime error. If there is a runtime error in progress, it's
+// returned.
+func (p *tracebackParser) start(info string) string {
+	previous := p.end()
+
+	p.info = info
+
+	return previous
+}
+
+// Parse a line received from the board after a runtime error. Returns the
+// runtime error info when the traceback ends, that is when a line that is
+// not part of the traceback is found, or an empty string.
+func (p *tracebackParser) parse(line string) string {
+	if p.info == "" {
+		return ""
+	}
+
+	if !p.inTraceback && tracebackStartRe.MatchString(line) {
+		p.inTraceback = true
+		return ""
+	}
+
+	if p.inTraceback && strings.HasPrefix(line, "\t") {
+		if parts := tracebackFrameRe.FindStringSubmatch(line); parts != nil {
+			frame := tracebackFrame{Source: parts[1], Function: parts[3]}
+			frame.Line, _ = strconv.Atoi(parts[2])
+
+			if name := tracebackFunctionRe.FindStringSubmatch(frame.Function); name != nil {
+				frame.Function = name[1]
+			}
+
+			p.frames = append(p.frames, frame)
+		}
+
+		return ""
+	}
+
+	return p.end()
+}
+
+// Check if a partial line received from the board ends the runtime error in
+// progress. This is needed because the prompt sent after the traceback has
+// not a new line.
+func (p *tracebackParser) partial(line string) string {
+	if p.info == "" || line == "" || line[0] == '\t' {
+		return ""
+	}
+
+	if strings.HasPrefix("stack traceback:", strings.TrimLeft(line, " ")) {
+		return ""
+	}
+
+	return p.end()
+}
+
+// End the runtime error in progress, and return the notification info with
+// the traceback frames
+func (p *tracebackParser) end() string {
+	info := p.info
+	if info == "" {
+		return ""
+	}
+
+	frames, err := json.Marshal(p.frames)
+	if err == nil && len(p.frames) > 0 {
+		info = info + ", \"traceback\": " + string(frames)
+	}
+
+	p.info = ""
+	p.inTraceback = false
+	p.frames = nil
+
+	return info
+}
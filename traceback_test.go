@@ -0,0 +1,132 @@
+/*
+ * Whitecat Console, Lua traceback parsing tests
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTracebackParser(t *testing.T) {
+	const info = `"where": "main.lua:3", "error": "boom"`
+
+	tests := []struct {
+		name    string
+		lines   []string
+		partial string
+		infos   []string
+	}{
+		{
+			"traceback",
+			[]string{
+				"stack traceback:",
+				"\t[C]: in function 'error'",
+				"\tmain.lua:3: in local 'fail'",
+				"\tmain.lua:6: in main chunk",
+				"\t[C]: in ?",
+				"/ > ",
+			},
+			"",
+			[]string{info + `, "traceback": [{"source":"[C]","function":"error"},{"source":"main.lua","line":3,"function":"fail"},{"source":"main.lua","line":6,"function":"main chunk"},{"source":"[C]","function":"?"}]`},
+		},
+		{
+			"without traceback",
+			[]string{"/ > "},
+			"",
+			[]string{info},
+		},
+		{
+			"ended by the prompt",
+			[]string{
+				"stack traceback:",
+				"\tmain.lua:3: in function <main.lua:1>",
+			},
+			"/ > ",
+			[]string{info + `, "traceback": [{"source":"main.lua","line":3,"function":"function \u003cmain.lua:1\u003e"}]`},
+		},
+		{
+			"partial traceback start",
+			[]string{},
+			"stack trace",
+			nil,
+		},
+		{
+			"partial frame",
+			[]string{"stack traceback:"},
+			"\tmain.lua",
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		var infos []string
+
+		p := tracebackParser{}
+		p.start(info)
+
+		for _, line := range test.lines {
+			if s := p.parse(line); s != "" {
+				infos = append(infos, s)
+			}
+		}
+
+		if s := p.partial(test.partial); s != "" {
+			infos = append(infos, s)
+		}
+
+		if !reflect.DeepEqual(infos, test.infos) {
+			t.Errorf("%s: infos are %v, expected %v", test.name, infos, test.infos)
+		}
+	}
+}
+
+func TestTracebackParserStart(t *testing.T) {
+	p := tracebackParser{}
+
+	if s := p.parse("stack traceback:"); s != "" {
+		t.Errorf("info without runtime error is %q, expected none", s)
+	}
+
+	if s := p.start(`"error": "first"`); s != "" {
+		t.Errorf("previous info is %q, expected none", s)
+	}
+
+	if s := p.start(`"error": "second"`); s != `"error": "first"` {
+		t.Errorf("previous info is %q, expected the first runtime error", s)
+	}
+
+	if s := p.end(); s != `"error": "second"` {
+		t.Errorf("info is %q, expected the second runtime error", s)
+	}
+
+	if s := p.end(); s != "" {
+		t.Errorf("info after end is %q, expected none", s)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Whitecat Console, transport abstraction
+ *
+ * Copyright (C) 2015 - 2016
+ * IBEROXARXA SERVICIOS INTEGRALES, S.L.
+ *
+ * Author: Jaume Olivé (jolive@iberoxarxa.com / jolive@whitecatboard.org)
+ *
+ * All rights reserved.
+ *
+ * Permission to use, copy, modify, and distribute this software
+ * and its documentation for any purpose and without fee is hereby
+ * granted, provided that the above copyright notice appear in all
+ * copies and that both that the copyright notice and this
+ * permission notice and warranty disclaimer appear in supporting
+ * documentation, and that the name of the author not be used in
+ * advertising or publicity pertaining to distribution of the
+ * software without specific, written prior permission.
+ *
+ * The author disclaim all warranties with regard to this
+ * software, including all implied warranties of merchantability
+ * and fitness.  In no event shall the author be liable for any
+ * special, indirect or consequential damages or any damages
+ * whatsoever resulting from loss of use, data or profits, whether
+ * in an action of contract, negligence or other tortious action,
+ * arising out of or in connection with the use or performance of
+ * this software.
+ */
+
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// A transport is the link used for talk with a board. The board code only
+// needs to read and write bytes, and to drive the control lines used for
+// reset the board, so any link providing this primitives can be used.
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Close() error
+
+	// Set the state of the RTS / DTR control lines
+	SetRTS(on bool) error
+	SetDTR(on bool) error
+
+	// Returns true if the transport can drive the control lines. If not,
+	// the board can't be reset or flashed through it.
+	HasControlLines() bool
+}
+
+var errNoControlLines = errors.New("transport has not control lines")
+
+// Open the transport for a port name. Port names with an URL scheme select
+// a network transport, for example tcp://192.168.1.10:23 or
+// rfc2217://192.168.1.10:4000, or the board simulator, sim://. Any other
+// port name is a local serial port.
+func openTransport(name string, baudRate int) (Transport, error) {
+	if strings.HasPrefix(name, "tcp://") {
+		return openNetTransport(strings.TrimPrefix(name, "tcp://"), false)
+	} else if strings.HasPrefix(name, "telnet://") {
+		return openNetTransport(strings.TrimPrefix(name, "telnet://"), true)
+	} else if strings.HasPrefix(name, "rfc2217://") {
+		return openRFC2217Transport(strings.TrimPrefix(name, "rfc2217://"), baudRate)
+	} else if isSimulator(name) {
+		return openSimTransport(name)
+	}
+
+	return openSerialTransport(name, baudRate)
+}